@@ -7,44 +7,123 @@ package main
 import (
 	"flag"
 	"fmt"
+	"net"
 	"os"
+	"strings"
 	"time"
+
+	"net.in.tum.de/ecsplorer/scan"
 )
 
 func parseFlags() {
 	flag.IntVar(&prefixLengthToScanWith, "pl", 24, "PREFIX LENGTH = Prefix length we will use for the 'Source' field in the ECS in all our scans")
 	flag.StringVar(&inputFile, "if", "", "INPUT FILE = The file in which the list of Domains we want to scan is stored.")
 	flag.StringVar(&storeDir, "out", "", "output Directory to write results")
-	flag.IntVar(&capacityForChannelsFlag, "cc", 100, "CAPACITY of CHANNELS = Number of Domains we can scan concurrently")
-	flag.IntVar(&numberOfIPGenerators, "ni", 20, "NUMBER of IPGENERATORS = Number of concurrently called IPGenerators")
+	flag.StringVar(&outputFormat, "output-format", scan.OUTPUT_CSV, "format of the result files, csv or jsonl")
+	flag.StringVar(&compression, "compress", scan.COMPRESSION_NONE, "compress the result files with gzip or zstd")
+	flag.Int64Var(&rotateBytes, "rotate-bytes", 0, "start a new part of a result file once it reaches this size in bytes, 0 to disable")
+	flag.Int64Var(&rotateRows, "rotate-rows", 0, "start a new part of a result file after this number of rows, 0 to disable")
+	flag.IntVar(&config.ChannelCapacity, "cc", config.ChannelCapacity, "CAPACITY of CHANNELS = Number of Domains we can scan concurrently")
+	flag.IntVar(&config.IPGenerators, "ni", config.IPGenerators, "NUMBER of IPGENERATORS = Number of concurrently called IPGenerators")
 	flag.IntVar(&loggingLevel, "ll", 2, " LOGGING LEVEL = Level of how much we log. 0 (no logging) 1(only errors), 2 (informational), 3 (debugging)")
 	flag.StringVar(&fileToLogTo, "lf", "", "LOGGING FILE = File we want to log into")
 	flag.StringVar(&queryListFile, "query-list", "", "List of query parameters to use instead of normal trie based approach")
-	flag.BoolVar(&printFinalResult, "pr", false, "PRINT RESULT = Indicates if final result shall be printed")
+	flag.StringVar(&config.Strategy, "strategy", "", "strategy choosing the client subnets of every domain: "+strings.Join(scan.StrategyNames(), ", ")+". Empty for list with -query-list and trie otherwise")
+	flag.BoolVar(&config.PrintFinalResult, "pr", false, "PRINT RESULT = Indicates if final result shall be printed")
 	flag.StringVar(&cpuProfileFile, "cp", "", "CPU PROFILE = File to which cpuProfile shall be written")
 	flag.StringVar(&memProfileFile, "mp", "", "MEMORY PROFILE = File to which memProfile shall be written")
 	flag.StringVar(&bgpPrefixFile, "pf", "", "PREFIX FILE = File where the bgp prefixes are stored")
 	flag.StringVar(&specialPrefixesFile, "sf", "", "SPECIAL PREFIX FILE = File where the bgp prefixes are stored")
-	flag.IntVar(&maximumTempErrors, "te", 3, "TEMPORARY ERRORS = maximum number of temporary errors we accept for one domain-name server pair before stop scanning it")
-	flag.IntVar(&queryRate, "query-rate", 100, "query rate per second,                                                    <= 0 for unlimited.")
-	flag.IntVar(&retries, "retries", 3, "number of retries on error")
-	flag.IntVar(&domainOutstanding, "domain-outstanding", 100, "maximum number of domains which are scanned at once,                      == 0 to disable.")
+	flag.IntVar(&config.MaxTempErrors, "te", config.MaxTempErrors, "TEMPORARY ERRORS = maximum number of temporary errors we accept for one domain-name server pair before stop scanning it")
+	flag.Float64Var(&config.QueryRate, "query-rate", config.QueryRate, "query rate per second, fractions like 0.5 are allowed, <= 0 for unlimited.")
+	flag.IntVar(&config.QueryBurst, "query-burst", 0, "number of queries which may be sent at once above the query rate, 0 for the queries of one second")
+	flag.IntVar(&config.Workers, "workers", 0, "number of queries in flight at once, 0 to use the query rate (at most 10000, 1000 if the rate is unlimited)")
+	flag.Float64Var(&config.NSQueryRate, "ns-query-rate", 0, "query rate per second to a single nameserver group, <= 0 for unlimited")
+	flag.IntVar(&config.NSQueryBurst, "ns-query-burst", 0, "number of queries which may be sent at once to a nameserver group, 0 for the queries of one second")
+	flag.IntVar(&config.NSConcurrency, "ns-concurrency", 0, "number of queries in flight at once to a nameserver group, 0 for unlimited")
+	flag.StringVar(&config.NSGroup, "ns-group", config.NSGroup, "nameservers sharing the caps: ip (each nameserver on its own), prefix (/24 or /48) or asn (needs -pfx2as)")
+	flag.BoolVar(&config.Adaptive, "adaptive", false, "adapt the rate of every nameserver group to timeouts and REFUSED responses")
+	flag.Float64Var(&config.AdaptiveFloor, "adaptive-floor", config.AdaptiveFloor, "lowest adaptive rate per second, below timeouts and REFUSED count as temporary errors")
+	flag.IntVar(&config.AdaptiveErrors, "adaptive-errors", config.AdaptiveErrors, "consecutive timeouts or REFUSED responses which halve the adaptive rate")
+	flag.StringVar(&pfx2asFile, "pfx2as", "", "prefix to AS file, e.g. from CAIDA, to group nameservers by origin AS")
+	flag.IntVar(&config.Retries, "retries", config.Retries, "number of retries on timeouts and other errors")
+	flag.IntVar(&config.TruncationRetries, "truncation-retries", config.TruncationRetries, "number of retries of the TCP query after a truncated response")
+	flag.BoolVar(&config.TCPFallback, "tcp-fallback", config.TCPFallback, "send the last retry after UDP errors over TCP")
+	flag.DurationVar(&config.RetryBackoff, "retry-backoff", config.RetryBackoff, "backoff before the first retry, it doubles with every retry, half of it is random. 0 to retry immediately")
+	flag.DurationVar(&config.RetryBackoffCap, "retry-backoff-cap", config.RetryBackoffCap, "maximum backoff between two retries")
+	flag.StringVar(&config.Transport, "transport", config.Transport, "transport of the queries: udp (falling back to tcp), tcp, tls (DoT) or https (DoH)")
+	flag.IntVar(&config.NameserverPort, "ns-port", 0, "port of nameservers given without one, 0 for the default port of the transport (53, 853 for tls, 443 for https)")
+	flag.StringVar(&config.TLSServerName, "tls-server-name", "", "name to verify the certificate of tls and https nameservers against, empty to verify their IP address")
+	flag.BoolVar(&config.TLSInsecure, "tls-insecure", false, "do not verify the certificate of tls and https nameservers, e.g. of local stand-ins")
+	flag.StringVar(&config.DoHPath, "doh-path", config.DoHPath, "URL path of DoH queries")
+	flag.IntVar(&config.StreamPipeline, "stream-pipeline", config.StreamPipeline, "queries in flight at once on a tcp or tls connection, a nameserver gets another connection above")
+	flag.DurationVar(&config.StreamIdleTimeout, "stream-idle-timeout", config.StreamIdleTimeout, "time after which tcp, tls and https connections without queries are closed")
+	flag.IntVar(&config.UDPSockets, "udp-sockets", config.UDPSockets, "Number of UDP sockets shared by all queries, 0 to open a new socket for every query")
+	flag.IntVar(&config.DomainOutstanding, "domain-outstanding", config.DomainOutstanding, "maximum number of domains which are scanned at once,                      == 0 to disable.")
 	flag.StringVar(&ip4flag, "ip4source", "", "ipv4 source address to use during the scan")
 	flag.StringVar(&ip6flag, "ip6source", "", "ipv6 source address to use during the scan")
-	flag.IntVar(&maxNumScopeZeros, "scope-zero-allowed", 10000, "Number of scope zeros to accepts,                                                    <= 0 for unlimited.")
+	flag.IntVar(&config.MaxScopeZeros, "scope-zero-allowed", config.MaxScopeZeros, "Number of scope zeros to accepts,                                                    <= 0 for unlimited.")
 	flag.BoolVar(&nostore, "disable-store", false, "disable all storage")
 	flag.BoolVar(&versionf, "version", false, "show version string")
-	flag.BoolVar(&ipv6Scan, "6", false, "Perfom IPv6 scan using BGPANNOUNCED prefixes as seed")
-	flag.IntVar(&randomizeDepth, "randomize-depth", 32, "Randomize scan prefix selection after a given depth")
-	flag.BoolVar(&scanAllBGP, "scanAllBGP", false, "Force scan all BGP announced prefixes from the prefix list")
+	flag.BoolVar(&config.IPv6, "6", false, "Perfom IPv6 scan using BGPANNOUNCED prefixes as seed")
+	flag.BoolVar(&config.DualStack, "dual", false, "scan every domain with IPv4 and IPv6 client subnets, -pl is the prefix length of IPv4 and -pl6 of IPv6")
+	flag.IntVar(&prefixLengthToScanWithIPv6, "pl6", 48, "prefix length of the IPv6 client subnets with -dual")
+	flag.IntVar(&config.RandomizeDepth, "randomize-depth", config.RandomizeDepth, "Randomize scan prefix selection after a given depth")
+	flag.BoolVar(&config.ScanAllBGP, "scanAllBGP", false, "Force scan all BGP announced prefixes from the prefix list")
 	flag.StringVar(&resolver, "resolver", "", "Set this to use a public resolver instead of the authoritative name server")
+	flag.StringVar(&qtypeflag, "qtype", "", "Query type, e.g. AAAA or HTTPS, can be overridden by a third column in the input file. Empty to query A for IPv4 and AAAA for IPv6 subnets")
 	flag.StringVar(&configFile, "config-file", "", "Config file path")
-	timeoutDial = flag.Duration("timeout-dial", 2*time.Second, "Dial timeout")
-	timeoutRead = flag.Duration("timeout-read", 2*time.Second, "Read timeout")
-	timeoutWrite = flag.Duration("timeout-write", 2*time.Second, "Write timeout")
+	flag.DurationVar(&config.TimeoutDial, "timeout-dial", config.TimeoutDial, "Dial timeout")
+	flag.DurationVar(&config.TimeoutRead, "timeout-read", config.TimeoutRead, "Read timeout")
+	flag.DurationVar(&config.TimeoutWrite, "timeout-write", config.TimeoutWrite, "Write timeout")
+	flag.DurationVar(&config.StatsInterval, "stats-interval", config.StatsInterval, "Interval to log the scan statistics, 0 to disable")
+	flag.StringVar(&metricsListen, "metrics-listen", "", "Address to serve Prometheus metrics on, e.g. localhost:9100, empty to disable")
+	flag.DurationVar(&checkpointInterval, "checkpoint-interval", 0, "Interval to write a checkpoint of the scan state into the output directory, 0 to disable")
+	flag.BoolVar(&resumeScan, "resume", false, "Resume the scan from the last checkpoint in the output directory")
+	flag.DurationVar(&config.MaxDuration, "max-duration", 0, "Time after which the scan is stopped and its queries in flight are abandoned, e.g. 6h to bound it to a measurement window, 0 for unlimited")
+	flag.DurationVar(&config.DomainTimeout, "domain-timeout", 0, "Time budget of a domain, after it no new queries are sent and the domain is finished with reason domainTimeout, 0 for unlimited")
+	flag.IntVar(&config.MaxQueriesPerDomain, "max-queries-per-domain", 0, "Client subnets queried at most per domain (and family), a domain needing more is finished with reason budgetExhausted, 0 for unlimited")
+	flag.IntVar(&config.MaxQueriesPerNameserver, "max-queries-per-ns", 0, "Client subnets queried at most per nameserver across all its domains, domains needing more are finished with reason budgetExhausted, 0 for unlimited")
+	flag.DurationVar(&shutdownTimeout, "shutdown-timeout", 30*time.Second, "Time to wait for outstanding queries after an interrupt before they are abandoned, a second interrupt abandons them right away")
 	flag.Parse()
 	if inputFile == "" {
 		fmt.Println("Please specify inputFile with -if")
 		os.Exit(0)
 	}
+	if outputFormat != scan.OUTPUT_CSV && outputFormat != scan.OUTPUT_JSONL {
+		fmt.Printf("Unknown output format '%v', use csv or jsonl\n", outputFormat)
+		os.Exit(2)
+	}
+	if qtypeflag != "" {
+		var err error
+		config.QueryType, err = scan.ParseQueryType(qtypeflag)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+	}
+	config.PrefixLengthIPv4 = prefixLengthToScanWith
+	config.PrefixLengthIPv6 = prefixLengthToScanWith
+	if config.DualStack {
+		config.PrefixLengthIPv6 = prefixLengthToScanWithIPv6
+	}
+	if ip4flag != "" {
+		config.LocalAddress = net.ParseIP(ip4flag).To4()
+		if config.LocalAddress == nil {
+			fmt.Println(ip4flag + " is not a IPv4 addr")
+			os.Exit(2)
+		}
+	}
+	if ip6flag != "" {
+		config.LocalAddress = net.ParseIP(ip6flag).To16()
+		if config.LocalAddress == nil {
+			fmt.Println(ip6flag + " is not a IPv6 addr")
+			os.Exit(2)
+		}
+	}
+	// with a resolver every domain is queried at the resolver instead of its nameserver
+	config.Recursive = resolver != ""
+	if compression == "none" {
+		compression = scan.COMPRESSION_NONE
+	}
 }
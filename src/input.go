@@ -0,0 +1,225 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+	"net.in.tum.de/ecsplorer/scan"
+)
+
+// readPrefixFile reads a file with one prefix per line, the prefixes of both families can be in the same file
+func readPrefixFile(path string, name string) []netip.Prefix {
+	file, err := os.Open(path)
+	if err != nil {
+		scan.ErrorLog("MAIN:   could not read File %v !", path)
+		panic("Could not read the file for " + name + " prefixes.")
+	}
+	defer file.Close()
+	var prefixes []netip.Prefix
+	scanner := bufio.NewScanner(file)
+	scanner.Split(bufio.ScanLines)
+	for scanner.Scan() {
+		prefix, err := netip.ParsePrefix(scanner.Text())
+		if err != nil {
+			scan.ErrorLog("Reading '%v' from File with %v prefixes produced error: %s", scanner.Text(), name, err)
+			continue
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes
+}
+
+// readInputFiles reads the prefix files into the config
+func readInputFiles() {
+	if bgpPrefixFile != "" {
+		config.BGPPrefixes = readPrefixFile(bgpPrefixFile, "BGPANNOUNCED")
+	} else {
+		scan.DebugLog("MAIN: No File was specified for BGPANNOUNCED announced prefixes. Will scan without.")
+	}
+	if specialPrefixesFile != "" {
+		config.SpecialPrefixes = readPrefixFile(specialPrefixesFile, "special")
+	} else {
+		scan.DebugLog("MAIN: No file for special prefixes specified. Scanning without")
+	}
+	if queryListFile != "" {
+		readQueryList()
+	}
+	if pfx2asFile != "" {
+		readPfx2as()
+	}
+}
+
+func readQueryList() {
+	file, err := os.Open(queryListFile)
+	if err != nil {
+		scan.ErrorLog("MAIN:   could not read File %v !", queryListFile)
+		panic("Could not read the file containg query list")
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Split(bufio.ScanLines)
+	for scanner.Scan() {
+		prefix, err := netip.ParsePrefix(scanner.Text())
+		if err != nil {
+			scan.ErrorLog(err.Error())
+			continue
+		}
+		config.QueryList = append(config.QueryList, prefix)
+	}
+}
+
+// readPfx2as reads a prefix to AS file, either in the CAIDA format "1.0.0.0	24	13335" or as "1.0.0.0/24 13335"
+func readPfx2as() {
+	file, err := os.Open(pfx2asFile)
+	if err != nil {
+		scan.ErrorLog("MAIN:   could not read File %v !", pfx2asFile)
+		panic("Could not read the prefix to AS file.")
+	}
+	defer file.Close()
+	config.Pfx2AS = make(map[netip.Prefix]string)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 3 {
+			fields = []string{fields[0] + "/" + fields[1], fields[2]}
+		}
+		if len(fields) != 2 {
+			scan.ErrorLog("Could not parse line '%v' of the prefix to AS file", scanner.Text())
+			continue
+		}
+		prefix, err := netip.ParsePrefix(fields[0])
+		if err != nil {
+			scan.ErrorLog("Reading '%v' from the prefix to AS file produced error: %s", scanner.Text(), err)
+			continue
+		}
+		config.Pfx2AS[prefix.Masked()] = fields[1]
+	}
+	scan.DebugLog("MAIN:    %v prefixes were read from the prefix to AS file.", len(config.Pfx2AS))
+}
+
+func getPrefixLimits() {
+	viper.SetConfigFile(configFile) // name of config file (without extension)
+	viper.SetConfigType("yaml")     // REQUIRED if the config file does not have the extension in the name
+	err := viper.ReadInConfig()     // Find and read the config file
+	if err != nil {                 // Handle errors reading the config file
+		panic(fmt.Errorf("fatal error config file: %w", err))
+	}
+	fmt.Println(viper.AllKeys())
+	if !config.IPv6 {
+		config.LimitsIPv4 = getFamilyLimits("ipv4")
+	}
+	if config.IPv6 || config.DualStack {
+		config.LimitsIPv6 = getFamilyLimits("ipv6")
+	}
+}
+
+// getFamilyLimits reads the limits of a family from its section of the config file.
+// maxSpecialPrefixScans, scanResultsToFinish and totalNotroutedLimit apply to both families unless the section sets them.
+func getFamilyLimits(family string) scan.Limits {
+	limitsName := family + "Limits"
+	limitBits := 32
+	if family == "ipv6" {
+		limitBits = 128
+	}
+	var limits scan.Limits
+	for kindLimits, section := range map[*map[int]int]string{&limits.Announced: "bgprouted", &limits.Unannounced: "notrouted", &limits.Total: "total"} {
+		*kindLimits = make(map[int]int)
+		limitsconf := viper.GetStringMapString(limitsName + "." + section)
+		for key, val := range limitsconf {
+			keyInt, err := strconv.Atoi(key)
+			if err != nil {
+				scan.ErrorLog("Could not convert %v to int prefixlength", key)
+				os.Exit(2)
+			}
+			if keyInt > limitBits {
+				scan.ErrorLog("'%v' prefix length is too long", key)
+				os.Exit(2)
+			}
+			valInt, err := strconv.Atoi(val)
+			if err != nil {
+				scan.ErrorLog("Could not convert %v to int prefixlength limit", val)
+				os.Exit(2)
+			}
+			(*kindLimits)[keyInt] = valInt
+		}
+	}
+	fmt.Println(limits.Announced, limits.Unannounced, limits.Total)
+	familyConfig := func(key string) string {
+		if viper.IsSet(limitsName + "." + key) {
+			return limitsName + "." + key
+		}
+		return key
+	}
+	limits.MaxSpecialPrefixScans = viper.GetInt(familyConfig("maxSpecialPrefixScans"))
+	fmt.Println(limits.MaxSpecialPrefixScans)
+	limits.ScanResultsToFinish = uint8(viper.GetInt(familyConfig("scanResultsToFinish")))
+	fmt.Println(limits.ScanResultsToFinish)
+	limits.TotalNotroutedLimit = viper.GetInt(familyConfig("totalNotroutedLimit"))
+	fmt.Println(limits.TotalNotroutedLimit)
+	return limits
+}
+
+// inputDomains returns the domains of the input file, a line is "domain,nameserver[,qtype[,transport]]".
+// With -resolver the nameserver column is ignored, lines which can't be parsed are logged and skipped.
+func inputDomains(fileBuf *bufio.Scanner) scan.Domains {
+	var resolverIP net.IP
+	var resolverPort int
+	if resolver != "" {
+		var err error
+		resolverIP, resolverPort, err = scan.ParseNameserver(resolver)
+		if err != nil {
+			scan.ErrorLog("resolver is set but cannot be converted to an IP address: %s", err)
+			os.Exit(1)
+		}
+	}
+
+	return scan.DomainFunc(func() (scan.Domain, bool) {
+		for fileBuf.Scan() {
+			domainAndNamerserver := fileBuf.Text()
+			splittedDomainAndNameserver := strings.Split(domainAndNamerserver, ",")
+			scan.DebugLog("DOMAINSTATE: reading line \"" + domainAndNamerserver + "\"")
+			domain := scan.Domain{Name: splittedDomainAndNameserver[0]}
+			if resolverIP != nil {
+				domain.Nameserver, domain.Port = resolverIP, resolverPort
+			} else {
+				if len(splittedDomainAndNameserver) < 2 {
+					scan.ErrorLog("Line '" + domainAndNamerserver + "' is missing a ,")
+					continue
+				}
+				var err error
+				domain.Nameserver, domain.Port, err = scan.ParseNameserver(splittedDomainAndNameserver[1])
+				if err != nil {
+					scan.ErrorLog("Line '%v': %s", domainAndNamerserver, err)
+					continue
+				}
+			}
+			if len(splittedDomainAndNameserver) > 2 && splittedDomainAndNameserver[2] != "" {
+				var err error
+				domain.QueryType, err = scan.ParseQueryType(splittedDomainAndNameserver[2])
+				if err != nil {
+					scan.ErrorLog("Line '%v': %s", domainAndNamerserver, err)
+					continue
+				}
+			}
+			if len(splittedDomainAndNameserver) > 3 && splittedDomainAndNameserver[3] != "" {
+				domain.Transport = strings.ToLower(strings.TrimSpace(splittedDomainAndNameserver[3]))
+				if err := scan.CheckTransport(domain.Transport); err != nil {
+					scan.ErrorLog("Line '%v': %s", domainAndNamerserver, err)
+					continue
+				}
+			}
+			return domain, true
+		}
+		return scan.Domain{}, false
+	})
+}
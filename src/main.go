@@ -6,19 +6,20 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"github.com/spf13/viper"
-	"math"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"runtime/pprof"
-	"slices"
-	"strconv"
-	"strings"
+	"sync"
 	"syscall"
 	"time"
+
+	"net.in.tum.de/ecsplorer/scan"
 )
 
 func startLogging() { //will  initialize the Logging functionality. This will depend on the level of Logging specified and the fileToLogTo specified. If no fileToLogTo was specified, we will use the standard error
@@ -36,269 +37,59 @@ func startLogging() { //will  initialize the Logging functionality. This will de
 	}
 	switch loggingLevel {
 	case 3:
-		Init_Logging(logFile, logFile, logFile)
+		scan.Init_Logging(logFile, logFile, logFile)
 	case 2:
-		Init_Logging(LogDiscard, logFile, logFile)
+		scan.Init_Logging(scan.LogDiscard, logFile, logFile)
 	case 1:
-		Init_Logging(LogDiscard, LogDiscard, logFile)
+		scan.Init_Logging(scan.LogDiscard, scan.LogDiscard, logFile)
 	case 0:
-		Init_Logging(logFile, logFile, logFile)
-	}
-}
-
-func readQueryList() {
-	if queryListFile == "" {
-		return
-	}
-	file, err := os.Open(queryListFile)
-	defer func(file *os.File) {
-		err := file.Close()
-		if err != nil {
-			errorlog("could not close querylist file")
-		}
-	}(file)
-	if err != nil {
-		errorlog("MAIN:   could not read File %v !", queryListFile)
-		panic("Could not read the file containg query list")
-
-	}
-	scanner := bufio.NewScanner(file)
-	scanner.Split(bufio.ScanLines)
-	for scanner.Scan() {
-		_, nextNet, err := net.ParseCIDR(scanner.Text())
-		if err != nil {
-			errorlog(err.Error())
-			continue
-		}
-		queryList = append(queryList, *nextNet)
-	}
-}
-
-func readSpecialprefixesAndInitializeCorespondingmap() {
-	specialPrefixes = make(map[int64][]int)
-	if specialPrefixesFile != "" {
-		fileSpecial, err := os.Open(specialPrefixesFile)
-		if err != nil {
-			errorlog("MAIN:   could not read File %v !", specialPrefixesFile)
-			panic("Could not read the file for special (e.g. private) prefixes.")
-
-		}
-		scanner := bufio.NewScanner(fileSpecial)
-		scanner.Split(bufio.ScanLines)
-		for scanner.Scan() {
-			nextSpecialNet := scanner.Text()
-			splittedNextSpecialnet := strings.Split(nextSpecialNet, "/")
-			prefixLength, err := strconv.Atoi(splittedNextSpecialnet[1])
-			if err != nil {
-				errorlog("Reading '%v' from File with special prefixes produced error: %s", nextSpecialNet, err)
-			} else {
-				ipAsInt := convertIPFromStringToKeyInt(splittedNextSpecialnet[0])
-				storedPrefixLengths, ok := specialPrefixes[ipAsInt]
-				if ok {
-					specialPrefixes[ipAsInt] = append(storedPrefixLengths, prefixLength)
-				} else {
-					specialPrefixes[ipAsInt] = []int{prefixLength}
-				}
-			}
-		}
-		for k := range specialPrefixes {
-			specialPrefixesSlice = append(specialPrefixesSlice, k)
-		}
-		debuglog("MAIN: Following Special Prefixes were stored with corresponding key value: %+v", specialPrefixes)
-	} else {
-		debuglog("MAIN: No file for special prefixes specified. Scanning without")
-	}
-}
-
-func readBGPprefixesAndInitializeMap() {
-	bgpPrefixes = make(map[int64][]int)
-	if bgpPrefixFile != "" {
-		fileBGP, err := os.Open(bgpPrefixFile)
-		if err != nil {
-			errorlog("MAIN:   could not read File %v !", bgpPrefixFile)
-			panic("Could not read the file for BGPANNOUNCED announced prefixes.")
-		}
-		scanner := bufio.NewScanner(fileBGP)
-		scanner.Split(bufio.ScanLines)
-		for scanner.Scan() {
-			nextBGPnet := scanner.Text()
-			splittedNextBGPnet := strings.Split(nextBGPnet, "/")
-			prefixLength, err := strconv.Atoi(splittedNextBGPnet[1])
-			if err != nil {
-				errorlog("Reading '%v' from File with special prefixes produced error: %s", nextBGPnet, err)
-			} else {
-				ipAsInt := convertIPFromStringToKeyInt(splittedNextBGPnet[0])
-				storedPrefixLengths, ok := bgpPrefixes[ipAsInt]
-				if ok {
-					bgpPrefixes[ipAsInt] = append(storedPrefixLengths, prefixLength)
-				} else {
-					bgpPrefixes[ipAsInt] = []int{prefixLength}
-				}
-			}
-		}
-		for k := range bgpPrefixes {
-			bgpPrefixesSlice = append(bgpPrefixesSlice, k)
-		}
-		slices.Sort(bgpPrefixesSlice)
-		debuglog("MAIN:    BGPANNOUNCED Prefixes were stored.")
-	} else {
-		debuglog("MAIN: No File was specified for BGPANNOUNCED announced prefixes. Will scan without.")
+		scan.Init_Logging(logFile, logFile, logFile)
 	}
-
 }
 
-func startScanner() {
-	if versionf {
-		return
-	}
+// createStoreDir creates the output directory, the one of a resumed scan has to exist
+func createStoreDir(resumed bool) {
 	if storeDir == "" && !nostore {
 		panic("did not specify a storagedir")
 	}
 
-	var err error
-	_, err = os.Stat(storeDir)
-	if err == nil {
+	_, err := os.Stat(storeDir)
+	if resumed {
+		if err != nil {
+			panic("storagedir '" + storeDir + "' of the resumed scan access err " + err.Error())
+		}
+	} else if err == nil {
 		panic("storagedir '" + storeDir + "' already exists")
 	} else if !os.IsNotExist(err) {
 		panic("storagedir '" + storeDir + "' access err " + err.Error())
-	}
-	err = os.MkdirAll(storeDir, 0750)
-	if ip4flag != "" {
-		ip4 := net.ParseIP(ip4flag).To4()
-		if ip4flag != "" && ip4 == nil {
-			panic(ip4flag + "is not a IPv4 addr")
-		}
-		InitScanner(ip4)
-	}
-	if ip6flag != "" {
-		ip6 := net.ParseIP(ip6flag).To16()
-		if ip6flag != "" && ip6 == nil {
-			panic(ip6flag + "is not a IPv6 addr")
-		}
-		InitScanner(ip6)
-	}
-
-	EcsResultWriter = SetupSynchronizedWriter(storeDir, "ecsresults.csv", ECSResultsHeader)
-
-	limiter = make(chan struct{}, queryRate)
-
-	for i := 0; i < queryRate; i++ {
-		limiter <- struct{}{}
-	}
-	go rateLimitFiller(queryRate)
-}
-
-// rateLimitFiller Runs each ms if necessary or 1/queryRateLimit
-func rateLimitFiller(queryRateLimit int) {
-	var begin time.Time
-	var wait time.Duration
-
-	var bucketRate int
-	if queryRateLimit >= 500 {
-		bucketRate = int(math.Ceil(float64(queryRateLimit) / 1000))
 	} else {
-		bucketRate = 1
-	}
-	defaultWait := time.Duration(bucketRate * int(time.Second) / queryRateLimit)
-
-	for {
-		begin = time.Now()
-		for i := 0; i < bucketRate; i++ {
-			limiter <- struct{}{}
-		}
-		wait = defaultWait - time.Now().Sub(begin)
-
-		if wait > 0 {
-			time.Sleep(wait)
-		}
+		os.MkdirAll(storeDir, 0750)
 	}
 }
 
-func getPrefixLimits() {
-	viper.SetConfigFile(configFile) // name of config file (without extension)
-	viper.SetConfigType("yaml")     // REQUIRED if the config file does not have the extension in the name
-	err := viper.ReadInConfig()     // Find and read the config file
-	if err != nil {                 // Handle errors reading the config file
-		panic(fmt.Errorf("fatal error config file: %w", err))
-	}
-	fmt.Println(viper.AllKeys())
-	limitsName := "ipv4Limits"
-	limitBits := 32
-	if ipv6Scan {
-		limitsName = "ipv6Limits"
-		limitBits = 128
-	}
-	ipv4limitsconf := viper.GetStringMapString(limitsName + ".bgprouted")
-	//	ipv4limits := make(map[int]int)
-	scanLimits[BGPANNOUNCED] = make([]int, 129)
-	scanLimits[UNANNOUNCED] = make([]int, 129)
-	scanLimits[TOTAL] = make([]int, 129)
-	for key, val := range ipv4limitsconf {
-		keyInt, err := strconv.Atoi(key)
-		if err != nil {
-			errorlog("Could not convert %v to int prefixlength", key)
-			os.Exit(2)
-		}
-		if keyInt > limitBits {
-			errorlog("'%v' prefix length is too long", key)
-			os.Exit(2)
-		}
-		valInt, err := strconv.Atoi(val)
-		if err != nil {
-			errorlog("Could not convert %v to int prefixlength limit", val)
-			os.Exit(2)
-		}
-		scanLimits[BGPANNOUNCED][keyInt] = valInt
-	}
-	ipv4limitsconf = viper.GetStringMapString(limitsName + ".notrouted")
-	//	ipv4limits := make(map[int]int)
-	for key, val := range ipv4limitsconf {
-		keyInt, err := strconv.Atoi(key)
-		if err != nil {
-			errorlog("Could not convert %v to int prefixlength", key)
-			os.Exit(2)
-		}
-		if keyInt > limitBits {
-			errorlog("'%v' prefix length is too long", key)
-			os.Exit(2)
-		}
-		valInt, err := strconv.Atoi(val)
-		if err != nil {
-			errorlog("Could not convert %v to int prefixlength limit", val)
-			os.Exit(2)
-		}
-		scanLimits[UNANNOUNCED][keyInt] = valInt
+// serveMetrics exposes the scan statistics in the Prometheus text exposition format under /metrics.
+// The listener is opened before returning so a wrong address stops the scan right away.
+func serveMetrics(address string, handler http.Handler) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		panic("can't listen for metrics on " + address + ": " + err.Error())
 	}
-	ipv4limitsconf = viper.GetStringMapString(limitsName + ".total")
-	for key, val := range ipv4limitsconf {
-		keyInt, err := strconv.Atoi(key)
-		if err != nil {
-			errorlog("Could not convert %v to int prefixlength", key)
-			os.Exit(2)
-		}
-		if keyInt > limitBits {
-			errorlog("'%v' prefix length is too long", key)
-			os.Exit(2)
-		}
-		valInt, err := strconv.Atoi(val)
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", handler)
+	scan.InfoLog("METRICS: Serving metrics on http://%v/metrics", listener.Addr())
+	go func() {
+		err := http.Serve(listener, mux)
 		if err != nil {
-			errorlog("Could not convert %v to int prefixlength limit", val)
-			os.Exit(2)
+			scan.ErrorLog("METRICS: Server stopped: %s", err)
 		}
-		scanLimits[TOTAL][keyInt] = valInt
-	}
-	fmt.Println(scanLimits)
-	maxSpecialPrefixScans = viper.GetInt("maxSpecialPrefixScans")
-	fmt.Println(maxSpecialPrefixScans)
-	scanResultsToFinish = uint8(viper.GetInt("scanResultsToFinish"))
-	fmt.Println(scanResultsToFinish)
-	totalNotroutedLimit = viper.GetInt("totalNotroutedLimit")
-	fmt.Println(totalNotroutedLimit)
+	}()
 }
 
 func main() {
 	interruptsChan := make(chan os.Signal, 1)
-	signal.Notify(interruptsChan, os.Interrupt, syscall.SIGPIPE)
+	signal.Notify(interruptsChan, os.Interrupt)
+	// nameservers closing pooled TCP and TLS connections raise SIGPIPE, the writes to them fail on their own
+	signal.Ignore(syscall.SIGPIPE)
 
 	parseFlags()
 	if versionf {
@@ -307,136 +98,164 @@ func main() {
 	}
 	startLogging()
 
-	if queryListFile == "" {
+	readInputFiles()
+	config.CheckpointDir = storeDir
+	config.CheckpointInterval = checkpointInterval
+	err := config.Validate()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	if !config.UsesQueryList() {
 		getPrefixLimits()
 	}
 
-	var resolverIP net.IP = nil
-	if resolver != "" {
-		resolverIP = net.ParseIP(resolver)
-		if resolverIP != nil {
-			if resolverIP.To4() != nil {
-				resolverIP = resolverIP.To4()
-			}
-		} else {
-			errorlog("resolver is set but cannot be converted to an IP address")
+	var resumeFrom *scan.Checkpoint
+	if resumeScan {
+		resumeFrom, err = scan.ReadCheckpoint(storeDir)
+		if err != nil {
+			scan.ErrorLog("MAIN: Could not read checkpoint from %v: %s", storeDir, err)
 			os.Exit(1)
 		}
+		scan.InfoLog("MAIN: Resuming scan from checkpoint of %v", resumeFrom.Time)
 	}
 
 	//For debugging purposes we note down the set flags
-	debuglog("MAIN: Flags are set and parsed. Logging has started")
-	startScanner()
-	infolog("ECS Scanner: Version: %s", version)
-	debuglog("cmdline: %s", os.Args)
+	scan.DebugLog("MAIN: Flags are set and parsed. Logging has started")
+	createStoreDir(resumeFrom != nil)
+	sink, err := scan.NewFileSink(scan.FileSinkConfig{
+		Dir:         storeDir,
+		Format:      outputFormat,
+		Compression: compression,
+		RotateBytes: rotateBytes,
+		RotateRows:  rotateRows,
+		Checkpoints: checkpointInterval > 0,
+		// the scope map is learned by the strategy, strategies querying the query list do not have one
+		ScopeMap: !config.UsesQueryList(),
+	}, resumeFrom)
+	if err != nil {
+		scan.ErrorLog("MAIN: %s", err)
+		os.Exit(2)
+	}
+	config.Sink = sink
+	config.ResumeFrom = resumeFrom
+	scanner, err := scan.New(config)
+	if err != nil {
+		scan.ErrorLog("MAIN: %s", err)
+		os.Exit(2)
+	}
+
+	writeStartManifest(resumeFrom != nil)
+	scan.InfoLog("ECS Scanner: Version: %s", version)
+	scan.DebugLog("cmdline: %s", os.Args)
 	flag.Visit(func(flag *flag.Flag) {
-		debuglog("MAIN:   Flag - %v has Value: %v", flag.Name, flag.Value)
+		scan.DebugLog("MAIN:   Flag - %v has Value: %v", flag.Name, flag.Value)
 	})
 
 	if cpuProfileFile != "" {
 		f, err := os.Create(cpuProfileFile)
 		if err != nil {
-			errorlog("MAIN:   Could not create File for CPU Profile %v", cpuProfileFile)
+			scan.ErrorLog("MAIN:   Could not create File for CPU Profile %v", cpuProfileFile)
 			panic(err)
 		}
 		err = pprof.StartCPUProfile(f)
 		if err != nil {
-			errorlog("could not start cpu profile")
+			scan.ErrorLog("could not start cpu profile")
 			panic(err)
 		}
-		defer pprof.StopCPUProfile()
 	}
 
+	if metricsListen != "" {
+		serveMetrics(metricsListen, scanner.MetricsHandler())
+	}
+	// cancelling ctx abandons the queries in flight, an interrupt first waits for them
+	ctx, abandonQueries := context.WithCancel(context.Background())
+	defer abandonQueries()
+	scanDone := make(chan struct{})
 	go func() {
 		<-interruptsChan
-		infolog("INTERRUPTED")
-		if cpuProfileFile != "" {
-			pprof.StopCPUProfile()
-		}
-		if memProfileFile != "" {
-			f, err := os.Create(memProfileFile)
-			if err != nil {
-				errorlog("Error while creating mem Profile file '%s' ; Error: %s", memProfileFile, err)
-			}
-			err = pprof.WriteHeapProfile(f)
-			if err != nil {
-				errorlog("Error while writing mem Profile; Error: %s", err)
-			}
-			err = f.Close()
-			if err != nil {
-				errorlog("Error while closing mem Profile; file '%s' ;Error: %s", memProfileFile, err)
-			}
-		}
+		scan.InfoLog("INTERRUPTED, waiting up to %v for outstanding queries", shutdownTimeout)
+		scanner.Stop()
+		select {
+		case <-scanDone:
+			// main finishes the scan
+			return
+		case <-time.After(shutdownTimeout):
+			scan.ErrorLog("MAIN: Outstanding queries did not finish within %v, abandoning them", shutdownTimeout)
+		case <-interruptsChan:
+			scan.ErrorLog("MAIN: Interrupted again, abandoning outstanding queries")
+		}
+		abandonQueries()
+		select {
+		case <-scanDone:
+			return
+		case <-interruptsChan:
+			scan.ErrorLog("MAIN: Interrupted again, exiting without waiting for the scan to stop")
+		}
+		finishScan(scanner, sink, true)
 		os.Exit(1)
 	}()
 
-	readBGPprefixesAndInitializeMap()
-	readSpecialprefixesAndInitializeCorespondingmap()
-	readQueryList()
-
 	fileInput, err := os.Open(inputFile)
 	if err != nil {
-		errorlog("MAIN:   could not read File %v !", inputFile)
+		scan.ErrorLog("MAIN:   could not read File %v !", inputFile)
 		panic("Could not read Input File")
 	}
-
-	fileBuf := bufio.NewScanner(fileInput)
-	fileBuf.Split(bufio.ScanLines)
-
 	defer func(fileInput *os.File) {
 		err := fileInput.Close()
 		if err != nil {
-			errorlog("MAIN: Could not close Input File: %v ", fileInput)
+			scan.ErrorLog("MAIN: Could not close Input File: %v ", fileInput)
 		}
 	}(fileInput)
+	fileBuf := bufio.NewScanner(fileInput)
+	fileBuf.Split(bufio.ScanLines)
+
+	err = scanner.Run(ctx, inputDomains(fileBuf))
+	close(scanDone)
+	if errors.Is(err, context.DeadlineExceeded) {
+		// the scan was bounded with -max-duration, it can be resumed like an interrupted one
+		scan.InfoLog("MAIN: Scan stopped after -max-duration %v", config.MaxDuration)
+		finishScan(scanner, sink, true)
+		return
+	} else if err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, scan.ErrStopped) {
+		scan.ErrorLog("MAIN: %s", err)
+	}
+	finishScan(scanner, sink, err != nil)
+	if err != nil {
+		os.Exit(1)
+	}
+}
 
-	var nextDomainState func() *domainState
-	nextDomainState = func() *domainState {
-		if fileBuf.Scan() {
-			domainAndNamerserver := fileBuf.Text()
-			splittedDomainAndNameserver := strings.Split(domainAndNamerserver, ",")
-			debuglog("DOMAINSTATE: reading line \"" + domainAndNamerserver + "\"")
-			var nameserverIP net.IP = nil
-			if resolverIP != nil {
-				nameserverIP = resolverIP
+var finishScanOnce sync.Once
+
+// finishScan stops the profiling, flushes all result files to disk, finalizes the manifest and logs a summary of the scan.
+// It is called once, either when the scan returned or when the shutdown timeout expired.
+func finishScan(scanner *scan.Scanner, sink *scan.FileSink, interrupted bool) {
+	finishScanOnce.Do(func() {
+		if cpuProfileFile != "" {
+			pprof.StopCPUProfile()
+		}
+		if memProfileFile != "" {
+			f, err := os.Create(memProfileFile)
+			if err != nil {
+				scan.ErrorLog("Error while creating mem Profile file '%s' ; Error: %s", memProfileFile, err)
 			} else {
-				if len(splittedDomainAndNameserver) < 2 {
-					errorlog("Line '" + domainAndNamerserver + "' is missing a ,")
-					return nextDomainState()
+				err = pprof.WriteHeapProfile(f)
+				if err != nil {
+					scan.ErrorLog("Error while writing mem Profile; Error: %s", err)
 				}
-				nameserverIP = net.ParseIP(splittedDomainAndNameserver[1])
-				if nameserverIP != nil {
-					if nameserverIP.To4() != nil {
-						nameserverIP = nameserverIP.To4()
-					}
-				} else {
-					errorlog("Could not parse nameserver IP %v", splittedDomainAndNameserver[1])
-					return nextDomainState()
+				err = f.Close()
+				if err != nil {
+					scan.ErrorLog("Error while closing mem Profile; file '%s' ;Error: %s", memProfileFile, err)
 				}
 			}
-			return &domainState{
-				domain:       splittedDomainAndNameserver[0],
-				nameserverIP: nameserverIP,
-				identifier:   domainIdentifier(splittedDomainAndNameserver[0], nameserverIP),
-			}
 		}
-		return nil
-	}
-
-	controller(nextDomainState) //the actual magic starts
-	if memProfileFile != "" {
-		f, err := os.Create(memProfileFile)
+		err := sink.Close()
 		if err != nil {
-			errorlog("Error while creating mem Profile file '%s' ; Error: %s", memProfileFile, err)
+			scan.ErrorLog("Error while closing the result files: %s", err)
 		}
-		errorMP := pprof.WriteHeapProfile(f)
-		if errorMP != nil {
-			errorlog("Error while writing mem Profile; Error: %s", err)
-		}
-		errorMP2 := f.Close()
-		if errorMP2 != nil {
-			errorlog("Error while closing mem Profile; file '%s' ;Error: %s", memProfileFile, err)
-		}
-	}
-	EcsResultWriter.Close()
+		writeStatistics(scanner.Statistics())
+		writeEndManifest(scanner.Statistics(), interrupted)
+		scanner.LogStatistics()
+	})
 }
@@ -0,0 +1,215 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"net.in.tum.de/ecsplorer/scan"
+)
+
+const manifestFileName = "manifest.json"
+const statsFileName = "stats.json"
+
+// manifest describes how the results in the output directory were produced.
+// It is written when the scan starts and finalized when it ends.
+type manifest struct {
+	Version     string            `json:"version"`
+	Host        string            `json:"host"`
+	CommandLine []string          `json:"commandLine"`
+	Flags       map[string]string `json:"flags"`
+	Config      manifestConfig    `json:"config"`
+	ConfigIPv6  *manifestConfig   `json:"configIPv6,omitempty"` // with -dual the configuration of the IPv6 client subnets, config is the one of IPv4
+	InputFiles  []manifestFile    `json:"inputFiles"`
+	Start       time.Time         `json:"start"`
+	Resumed     []time.Time       `json:"resumed,omitempty"`
+	End         *time.Time        `json:"end,omitempty"`
+	Interrupted bool              `json:"interrupted"`
+	Statistics  *scan.Statistics  `json:"statistics,omitempty"`
+}
+
+// manifestConfig is the effective configuration of a family read from the config file
+type manifestConfig struct {
+	Family                string                    `json:"family"`
+	PrefixLength          int                       `json:"prefixLength"`
+	ScanLimits            map[string]map[string]int `json:"scanLimits"`
+	MaxSpecialPrefixScans int                       `json:"maxSpecialPrefixScans"`
+	ScanResultsToFinish   uint8                     `json:"scanResultsToFinish"`
+	TotalNotroutedLimit   int                       `json:"totalNotroutedLimit"`
+}
+
+type manifestFile struct {
+	Flag   string `json:"flag"`
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+var scanManifest *manifest
+
+// writeStartManifest collects the configuration of the scan and writes the manifest to the output directory.
+// A resumed scan keeps the manifest of the original scan and only notes the time it was resumed.
+func writeStartManifest(resumed bool) {
+	if resumed {
+		previous, err := readManifest(storeDir)
+		if err == nil {
+			scanManifest = previous
+			scanManifest.Resumed = append(scanManifest.Resumed, time.Now())
+			scanManifest.End = nil
+			scanManifest.Interrupted = false
+			writeManifest()
+			return
+		}
+		scan.ErrorLog("MANIFEST: could not read manifest of the resumed scan, writing a new one: %s", err)
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		scan.ErrorLog("MANIFEST: could not get host name: %s", err)
+	}
+	scanManifest = &manifest{
+		Version:     version,
+		Host:        host,
+		CommandLine: os.Args,
+		Flags:       make(map[string]string),
+		Start:       time.Now(),
+	}
+	if config.IPv6 {
+		scanManifest.Config = familyManifestConfig("ipv6", config.PrefixLengthIPv6, config.LimitsIPv6)
+	} else {
+		scanManifest.Config = familyManifestConfig("ipv4", config.PrefixLengthIPv4, config.LimitsIPv4)
+	}
+	if config.DualStack {
+		configIPv6 := familyManifestConfig("ipv6", config.PrefixLengthIPv6, config.LimitsIPv6)
+		scanManifest.ConfigIPv6 = &configIPv6
+	}
+	flag.VisitAll(func(f *flag.Flag) {
+		scanManifest.Flags[f.Name] = f.Value.String()
+	})
+	for _, input := range []struct{ flag, path string }{
+		{"if", inputFile},
+		{"pf", bgpPrefixFile},
+		{"sf", specialPrefixesFile},
+		{"query-list", queryListFile},
+		{"pfx2as", pfx2asFile},
+		{"config-file", configFile},
+	} {
+		if input.path == "" {
+			continue
+		}
+		file, err := hashFile(input.path)
+		if err != nil {
+			scan.ErrorLog("MANIFEST: could not hash %v: %s", input.path, err)
+			continue
+		}
+		file.Flag = input.flag
+		scanManifest.InputFiles = append(scanManifest.InputFiles, file)
+	}
+	writeManifest()
+}
+
+func familyManifestConfig(family string, prefixLength int, limits scan.Limits) manifestConfig {
+	familyConfig := manifestConfig{
+		Family:                family,
+		PrefixLength:          prefixLength,
+		ScanLimits:            make(map[string]map[string]int),
+		MaxSpecialPrefixScans: limits.MaxSpecialPrefixScans,
+		ScanResultsToFinish:   limits.ScanResultsToFinish,
+		TotalNotroutedLimit:   limits.TotalNotroutedLimit,
+	}
+	for name, kindLimits := range map[string]map[int]int{"bgpannounced": limits.Announced, "notrouted": limits.Unannounced, "total": limits.Total} {
+		manifestLimits := make(map[string]int)
+		for prefixLength, limit := range kindLimits {
+			if limit != 0 {
+				manifestLimits[strconv.Itoa(prefixLength)] = limit
+			}
+		}
+		familyConfig.ScanLimits[name] = manifestLimits
+	}
+	return familyConfig
+}
+
+// writeEndManifest adds the end time and the final statistics to the manifest
+func writeEndManifest(statistics scan.Statistics, interrupted bool) {
+	if scanManifest == nil {
+		return
+	}
+	end := time.Now()
+	scanManifest.End = &end
+	scanManifest.Interrupted = interrupted
+	scanManifest.Statistics = &statistics
+	writeManifest()
+}
+
+// writeStatistics writes the final statistics of the scan to the output directory
+func writeStatistics(statistics scan.Statistics) {
+	if storeDir == "" {
+		return
+	}
+	content, err := json.MarshalIndent(statistics, "", "  ")
+	if err != nil {
+		scan.ErrorLog("STATS: could not encode statistics: %s", err)
+		return
+	}
+	err = os.WriteFile(filepath.Join(storeDir, statsFileName), append(content, '\n'), 0640)
+	if err != nil {
+		scan.ErrorLog("STATS: could not write statistics: %s", err)
+	}
+}
+
+func hashFile(path string) (manifestFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return manifestFile{}, err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return manifestFile{}, err
+	}
+	return manifestFile{Path: path, Size: size, SHA256: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+func readManifest(dir string) (*manifest, error) {
+	content, err := os.ReadFile(filepath.Join(dir, manifestFileName))
+	if err != nil {
+		return nil, err
+	}
+	var m manifest
+	err = json.Unmarshal(content, &m)
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// writeManifest replaces the manifest in the output directory
+func writeManifest() {
+	if storeDir == "" {
+		return
+	}
+	content, err := json.MarshalIndent(scanManifest, "", "  ")
+	if err != nil {
+		scan.ErrorLog("MANIFEST: could not encode manifest: %s", err)
+		return
+	}
+	tmpFile := filepath.Join(storeDir, manifestFileName+".tmp")
+	err = os.WriteFile(tmpFile, append(content, '\n'), 0640)
+	if err == nil {
+		err = os.Rename(tmpFile, filepath.Join(storeDir, manifestFileName))
+	}
+	if err != nil {
+		scan.ErrorLog("MANIFEST: could not write manifest: %s", err)
+	}
+}
@@ -0,0 +1,99 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package scan
+
+import (
+	"math"
+	"sync"
+)
+
+// Parameters of the adaptive rate of a nameserver group
+const (
+	ADAPTIVE_INCREASE = 1   // queries per second added for every answered query
+	ADAPTIVE_DECREASE = 0.5 // factor applied to the rate after consecutive timeouts or REFUSED
+)
+
+// adaptiveRate adapts the rate of a nameserver group to its responses (AIMD).
+// Timeouts and REFUSED are taken as sign that the nameserver limits us, the rate is decreased and
+// the query is repeated later. Only once the rate reached the floor these errors count as temporary errors of the domain.
+type adaptiveRate struct {
+	mutex             sync.Mutex
+	limiter           *tokenBucket
+	rate              float64
+	ceiling           float64
+	floor             float64
+	errors            int // consecutive congestion errors after which the rate is decreased
+	consecutiveErrors int
+	maxRepeats        int // times a query is repeated at most, enough to decrease the rate from the ceiling to the floor
+	stats             *scanStatistics
+	logger            *Logger
+}
+
+func newAdaptiveRate(limiter *tokenBucket, ceiling float64, floor float64, errors int, stats *scanStatistics, logger *Logger) *adaptiveRate {
+	halvings := 1
+	if floor > 0 && ceiling > floor {
+		halvings = int(math.Ceil(math.Log(ceiling/floor) / math.Log(1/ADAPTIVE_DECREASE)))
+	}
+	return &adaptiveRate{
+		limiter:    limiter,
+		rate:       ceiling,
+		ceiling:    ceiling,
+		floor:      floor,
+		errors:     errors,
+		maxRepeats: max(1, errors) * max(1, halvings),
+		stats:      stats,
+		logger:     logger,
+	}
+}
+
+// adaptiveCeiling returns the highest rate of a nameserver group, the rate cap of the group or the global rate
+func (scanner *Scanner) adaptiveCeiling() float64 {
+	if scanner.config.NSQueryRate > 0 {
+		return scanner.config.NSQueryRate
+	}
+	if scanner.config.QueryRate > 0 {
+		return scanner.config.QueryRate
+	}
+	return 1000
+}
+
+func isCongestion(error ErrorType) bool {
+	return error == INTERNAL_ERR || error == REFUSED
+}
+
+// isPermanent reports whether an error ends the scan of a domain. With adaptive rates timeouts and REFUSED are
+// congestion, which reach the controller once the rate of the nameserver group is at the floor; they only count as temporary errors.
+func (scanner *Scanner) isPermanent(error ErrorType) bool {
+	if scanner.config.Adaptive && isCongestion(error) {
+		return false
+	}
+	return isPerm(error)
+}
+
+// update adapts the rate to the error type of a response, it returns true if the query should be repeated
+func (adaptive *adaptiveRate) update(error ErrorType) bool {
+	adaptive.mutex.Lock()
+	defer adaptive.mutex.Unlock()
+	if !isCongestion(error) {
+		adaptive.consecutiveErrors = 0
+		if adaptive.rate < adaptive.ceiling {
+			adaptive.rate = math.Min(adaptive.ceiling, adaptive.rate+ADAPTIVE_INCREASE)
+			adaptive.limiter.setRate(adaptive.rate)
+		}
+		return false
+	}
+	if adaptive.rate <= adaptive.floor {
+		return false
+	}
+	adaptive.consecutiveErrors++
+	if adaptive.consecutiveErrors >= adaptive.errors {
+		adaptive.consecutiveErrors = 0
+		adaptive.rate = math.Max(adaptive.floor, adaptive.rate*ADAPTIVE_DECREASE)
+		adaptive.limiter.setRate(adaptive.rate)
+		adaptive.stats.backoffs.Add(1)
+		adaptive.logger.debuglog("ADAPTIVE: Decreased rate to %v", adaptive.rate)
+	}
+	return true
+}
@@ -0,0 +1,83 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package scan
+
+import (
+	"maps"
+	"sync"
+)
+
+// nameserverBudget counts the queries sent to every nameserver across all its domains, see Config.MaxQueriesPerNameserver
+type nameserverBudget struct {
+	mutex sync.Mutex
+	limit int
+	used  map[string]int // queries per nameserver address
+}
+
+func newNameserverBudget(limit int) *nameserverBudget {
+	return &nameserverBudget{limit: limit, used: make(map[string]int)}
+}
+
+// take reserves up to wanted queries of the budget of the nameserver and returns how many were granted
+func (budget *nameserverBudget) take(nameserver string, wanted int) int {
+	budget.mutex.Lock()
+	defer budget.mutex.Unlock()
+	granted := min(wanted, budget.limit-budget.used[nameserver])
+	if granted <= 0 {
+		return 0
+	}
+	budget.used[nameserver] += granted
+	return granted
+}
+
+// snapshot returns the queries counted per nameserver for a checkpoint
+func (budget *nameserverBudget) snapshot() map[string]int {
+	budget.mutex.Lock()
+	defer budget.mutex.Unlock()
+	return maps.Clone(budget.used)
+}
+
+// restore continues counting from a checkpoint
+func (budget *nameserverBudget) restore(used map[string]int) {
+	budget.mutex.Lock()
+	defer budget.mutex.Unlock()
+	maps.Copy(budget.used, used)
+}
+
+/*
+applyQueryBudget cuts the queries of a result to the budgets left for its domain (Config.MaxQueriesPerDomain) and its nameserver
+(Config.MaxQueriesPerNameserver). Retries are not counted, every client subnet is counted once.
+Once the generator of a domain wanted more queries than its budgets allow, it is not asked again: the domain is finished with
+reason budgetExhausted as soon as none of its queries is outstanding, so it is told apart from domains finished by the generator.
+*/
+func (scanner *Scanner) applyQueryBudget(result *ipGeneratorResult) *ipGeneratorResult {
+	domainState := result.domainState
+	allowed := len(result.queries)
+	if scanner.config.MaxQueriesPerDomain > 0 {
+		allowed = max(0, min(allowed, scanner.config.MaxQueriesPerDomain-domainState.queries))
+	}
+	if scanner.nsBudget != nil && allowed > 0 {
+		allowed = scanner.nsBudget.take(domainState.nameserverIP.String(), allowed)
+	}
+	if allowed == len(result.queries) {
+		return result
+	}
+	domainState.budgetExhausted = true
+	if allowed == 0 {
+		return budgetExhausted(domainState)
+	}
+	result.queries = result.queries[:allowed]
+	return result
+}
+
+// budgetExhausted finishes a domain which ran out of its query budget once none of its queries is outstanding
+func budgetExhausted(domainState *domainState) *ipGeneratorResult {
+	if domainState.inFlight > 0 {
+		return waitingForMoreResults(domainState)
+	}
+	domainState.scanner.config.Logger.debuglog("IPGENERATOR: Domain %v ran out of its query budget, finishing scanning", domainState.domain)
+	domainState.finishReason = FINISHED_BUDGET_EXHAUSTED
+	return domainScanFinished(domainState)
+}
@@ -0,0 +1,463 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package scan
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+const checkpointFileName = "checkpoint.gob"
+
+// checkpointVersion is increased whenever the checkpoint changes in a way older scanners can't read or newer ones can't resume
+const checkpointVersion = 3
+
+// Checkpoint is the state of a scan written to the checkpoint directory, it is only taken while no query or generator request is in flight
+type Checkpoint struct {
+	Version   int // checkpointVersion of the scanner which wrote it
+	Time      time.Time
+	InputLine int64                     // number of domains taken from the input, lines of the input file with errors are not counted
+	Finished  []checkpointFinished      // domains finished past InputLine, those before it are skipped with the input
+	Domains   []checkpointDomain        // domains which were outstanding
+	Writers   map[string]writerPosition // position of each file of a FileSink, later rows are discarded on resume
+	Stats     Statistics
+	NSQueries map[string]int // queries per nameserver counted against Config.MaxQueriesPerNameserver
+}
+
+type checkpointDomain struct {
+	Domain            string
+	NameserverIP      net.IP
+	NameserverPort    int
+	Transport         string
+	Family            uint8 // ECS family of the client subnets
+	QueryType         uint16
+	TempErrors        uint8
+	PermError         bool
+	Trie              []byte
+	ListResponseIndex int
+	ListScanIndex     int
+	Queries           int                 // queries counted against Config.MaxQueriesPerDomain
+	BudgetExhausted   bool                // the domain is finished once its pending queries returned
+	TimeLeft          time.Duration       // rest of the time budget (Config.DomainTimeout), negative once it ran out, 0 if it did not start
+	Pending           []checkpointRequest // requests the generator created but which were not sent yet
+	ScopeMap          []checkpointScope
+}
+
+type checkpointFinished struct {
+	Identifier string
+	InputLine  int64 // domains taken from the input up to and including this one
+}
+
+type checkpointScope struct {
+	Prefix    []uint8 // one byte per bit
+	Scope     byte
+	Responses int
+	Answers   []string
+}
+
+type checkpointRequest struct {
+	List    bool // the queries are a request list, otherwise it is a single query
+	Queries []checkpointQuery
+}
+
+type checkpointQuery struct {
+	Address            net.IP
+	SourcePrefixLength byte
+	Family             byte
+}
+
+// checkpointer writes checkpoints for the controller
+type checkpointer struct {
+	scanner   *Scanner
+	dir       string
+	interval  time.Duration
+	inputLine func() int64
+	finished  []checkpointFinished // finished domains past the input line of the last checkpoint
+}
+
+// domainFinished records a domain which does not need to be scanned again on resume.
+// Domains up to the current input line are skipped on resume anyway, so only those taken later are kept.
+func (c *checkpointer) domainFinished(domainState *domainState) {
+	if domainState.inputLine > c.inputLine() {
+		c.finished = append(c.finished, checkpointFinished{Identifier: domainState.identifier, InputLine: domainState.inputLine})
+	}
+}
+
+// write stores the state of all outstanding domains together with the requests held back by the controller.
+// It must only be called while no domain state is used by a generator or scanner.
+func (c *checkpointer) write(domains map[string]*domainState, held []*ipGeneratorResult) error {
+	start := time.Now()
+	cp := Checkpoint{
+		Version:   checkpointVersion,
+		Time:      start,
+		InputLine: c.inputLine(),
+		Stats:     c.scanner.stats.snapshot(),
+	}
+	// the input line moved past some of the finished domains since the last checkpoint
+	c.finished = slices.DeleteFunc(c.finished, func(finished checkpointFinished) bool { return finished.InputLine <= cp.InputLine })
+	cp.Finished = c.finished
+	if c.scanner.nsBudget != nil {
+		cp.NSQueries = c.scanner.nsBudget.snapshot()
+	}
+
+	pending := make(map[*domainState][]checkpointRequest)
+	for _, request := range held {
+		if len(request.queries) == 0 {
+			continue
+		}
+		cpRequest := checkpointRequest{List: request.kind == RESULT_LIST}
+		for _, query := range request.queries {
+			cpRequest.Queries = append(cpRequest.Queries, toCheckpointQuery(query))
+		}
+		pending[request.domainState] = append(pending[request.domainState], cpRequest)
+	}
+
+	for _, domainState := range domains {
+		cpDomain := checkpointDomain{
+			Domain:            domainState.domain,
+			NameserverIP:      domainState.nameserverIP,
+			NameserverPort:    domainState.nameserverPort,
+			Transport:         domainState.transport,
+			Family:            domainState.family.number(),
+			QueryType:         domainState.qtype,
+			TempErrors:        domainState.tempErrors,
+			PermError:         domainState.permError,
+			ListResponseIndex: domainState.listResponseIndex,
+			ListScanIndex:     domainState.listScanIndex,
+			Queries:           domainState.queries,
+			BudgetExhausted:   domainState.budgetExhausted,
+			TimeLeft:          timeLeft(domainState.deadline, start),
+			Pending:           pending[domainState],
+		}
+		for _, entry := range domainState.scopeMap {
+			cpDomain.ScopeMap = append(cpDomain.ScopeMap, checkpointScope{
+				Prefix:    entry.prefix.field(),
+				Scope:     entry.scope,
+				Responses: entry.responses,
+				Answers:   entry.answers,
+			})
+		}
+		if domainState.state != nil {
+			var buf bytes.Buffer
+			encodeTrie(&buf, domainState.state)
+			cpDomain.Trie = buf.Bytes()
+		}
+		cp.Domains = append(cp.Domains, cpDomain)
+	}
+
+	if sink, ok := c.scanner.config.Sink.(*FileSink); ok {
+		var err error
+		cp.Writers, err = sink.checkpointPositions()
+		if err != nil {
+			return err
+		}
+	}
+
+	tmpFile := filepath.Join(c.dir, checkpointFileName+".tmp")
+	f, err := os.Create(tmpFile)
+	if err != nil {
+		return err
+	}
+	fileWriter := bufio.NewWriter(f)
+	err = gob.NewEncoder(fileWriter).Encode(&cp)
+	if err == nil {
+		err = fileWriter.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	closeErr := f.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	err = os.Rename(tmpFile, filepath.Join(c.dir, checkpointFileName))
+	if err != nil {
+		return err
+	}
+	c.scanner.config.Logger.infolog("CHECKPOINT: wrote checkpoint with %v outstanding and %v finished domains in %v", len(cp.Domains), len(cp.Finished), time.Since(start))
+	return nil
+}
+
+// timeLeft returns the rest of the time budget of a domain at the time of the checkpoint, 0 if it did not start
+func timeLeft(deadline time.Time, now time.Time) time.Duration {
+	if deadline.IsZero() {
+		return 0
+	}
+	if left := deadline.Sub(now); left != 0 {
+		return left
+	}
+	return -1
+}
+
+func toCheckpointQuery(request *queryRequest) checkpointQuery {
+	return checkpointQuery{
+		Address:            request.ipAddressClient,
+		SourcePrefixLength: request.sourcePrefixLength,
+		Family:             request.family,
+	}
+}
+
+// ReadCheckpoint loads the last checkpoint from the checkpoint directory, it must have been written by a scanner with the same checkpointVersion
+func ReadCheckpoint(dir string) (*Checkpoint, error) {
+	f, err := os.Open(filepath.Join(dir, checkpointFileName))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var cp Checkpoint
+	err = gob.NewDecoder(bufio.NewReader(f)).Decode(&cp)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode checkpoint: %w", err)
+	}
+	if cp.Version != checkpointVersion {
+		return nil, fmt.Errorf("checkpoint has version %v, this scanner only resumes version %v, resume it with the version of ecsplorer which wrote it", cp.Version, checkpointVersion)
+	}
+	return &cp, nil
+}
+
+// restoreDomains rebuilds the state of the outstanding domains and returns the requests which have to be sent again
+func (scanner *Scanner) restoreDomains(cp *Checkpoint) ([]*domainState, []*ipGeneratorResult, error) {
+	var domains []*domainState
+	var requests []*ipGeneratorResult
+	for _, cpDomain := range cp.Domains {
+		family := scanner.familyByNumber(cpDomain.Family)
+		domainState := &domainState{
+			scanner:           scanner,
+			domain:            cpDomain.Domain,
+			nameserverIP:      cpDomain.NameserverIP,
+			nameserverPort:    cpDomain.NameserverPort,
+			transport:         cpDomain.Transport,
+			qtype:             cpDomain.QueryType,
+			family:            family,
+			identifier:        scanner.domainIdentifier(cpDomain.Domain, cpDomain.NameserverIP, cpDomain.NameserverPort, cpDomain.Transport, cpDomain.QueryType, family),
+			tempErrors:        cpDomain.TempErrors,
+			permError:         cpDomain.PermError,
+			listResponseIndex: cpDomain.ListResponseIndex,
+			listScanIndex:     cpDomain.ListScanIndex,
+			queries:           cpDomain.Queries,
+			budgetExhausted:   cpDomain.BudgetExhausted,
+		}
+		// the time budget continues where it stopped, queries are sent again right away so it is started for all domains
+		if cpDomain.TimeLeft != 0 {
+			domainState.deadline = time.Now().Add(cpDomain.TimeLeft)
+		} else if scanner.config.DomainTimeout > 0 {
+			domainState.deadline = time.Now().Add(scanner.config.DomainTimeout)
+		}
+		if cpDomain.Trie != nil {
+			trie, err := decodeTrie(bytes.NewReader(cpDomain.Trie))
+			if err != nil {
+				return nil, nil, fmt.Errorf("could not restore trie of %v from checkpoint: %w", domainState.identifier, err)
+			}
+			trie.family = domainState.family
+			domainState.state = trie
+		}
+		for _, cpScope := range cpDomain.ScopeMap {
+			if domainState.scopeMap == nil {
+				domainState.scopeMap = make(map[ipPrefix]*scopeMapEntry, len(cpDomain.ScopeMap))
+			}
+			prefix := prefixFromField(cpScope.Prefix)
+			domainState.scopeMap[prefix] = &scopeMapEntry{
+				prefix:    prefix,
+				scope:     cpScope.Scope,
+				responses: cpScope.Responses,
+				answers:   cpScope.Answers,
+			}
+		}
+		domains = append(domains, domainState)
+
+		for _, cpRequest := range cpDomain.Pending {
+			var requestList []*queryRequest
+			for _, query := range cpRequest.Queries {
+				requestList = append(requestList, &queryRequest{
+					ipAddressClient:    query.Address,
+					sourcePrefixLength: query.SourcePrefixLength,
+					family:             query.Family,
+					domainState:        domainState,
+				})
+			}
+			domainState.inFlight += len(requestList)
+			if cpRequest.List {
+				requests = append(requests, queryRequestList(domainState, requestList))
+			} else {
+				requests = append(requests, singleQuery(requestList[0]))
+			}
+		}
+	}
+	return domains, requests, nil
+}
+
+// finishedSet returns the identifiers of all domains which were finished or are restored from the checkpoint
+func (scanner *Scanner) finishedSet(cp *Checkpoint) map[string]struct{} {
+	finished := make(map[string]struct{}, len(cp.Finished)+len(cp.Domains))
+	for _, cpFinished := range cp.Finished {
+		finished[cpFinished.Identifier] = struct{}{}
+	}
+	for _, cpDomain := range cp.Domains {
+		finished[scanner.domainIdentifier(cpDomain.Domain, cpDomain.NameserverIP, cpDomain.NameserverPort, cpDomain.Transport, cpDomain.QueryType, scanner.familyByNumber(cpDomain.Family))] = struct{}{}
+	}
+	return finished
+}
+
+// Trie serialization
+// Every element starts with a tag byte followed by its fields, children are written depth first.
+
+const (
+	trieTagNil = iota
+	trieTagNode
+	trieTagLeaf
+)
+
+func encodeTrie(buf *bytes.Buffer, trie *root) {
+	buf.Write(binary.AppendVarint(nil, int64(trie.scopeZeroObserved)))
+	buf.WriteByte(boolToByte(trie.rootIsScanned))
+	for _, child := range trie.childs {
+		encodeTrieElement(buf, child)
+	}
+}
+
+func encodeTrieElement(buf *bytes.Buffer, element trieElement) {
+	switch element := element.(type) {
+	case *node:
+		buf.WriteByte(trieTagNode)
+		buf.WriteByte(element.counterReturnedAsScope)
+		buf.WriteByte(element.nodeScans)
+		buf.Write(binary.AppendVarint(nil, int64(element.scansAnounced)))
+		buf.Write(binary.AppendVarint(nil, int64(element.scansUnanounced)))
+		buf.WriteByte(element.whichKindofPrefix)
+		buf.WriteByte(boolToByte(element.hasBGPsubnet))
+		buf.WriteByte(boolToByte(element.isAnnounced))
+		buf.WriteByte(element.value)
+		for _, child := range element.childs {
+			encodeTrieElement(buf, child)
+		}
+	case *leaf:
+		buf.WriteByte(trieTagLeaf)
+		buf.WriteByte(element.leafScanned)
+		buf.Write(binary.AppendVarint(nil, int64(element.scansAnnounced)))
+		buf.Write(binary.AppendVarint(nil, int64(element.scansUnnanounced)))
+		buf.WriteByte(element.whichKindofPrefix)
+		buf.WriteByte(boolToByte(element.hasBGPsubnet))
+		buf.WriteByte(element.value)
+		buf.WriteByte(boolToByte(element.isAnnounced))
+	default:
+		buf.WriteByte(trieTagNil)
+	}
+}
+
+type trieReader interface {
+	io.ByteReader
+	io.Reader
+}
+
+func decodeTrie(reader trieReader) (*root, error) {
+	scopeZeroObserved, err := binary.ReadVarint(reader)
+	if err != nil {
+		return nil, err
+	}
+	rootIsScanned, err := reader.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	trie := &root{scopeZeroObserved: int(scopeZeroObserved), rootIsScanned: rootIsScanned == 1}
+	for i := range trie.childs {
+		trie.childs[i], err = decodeTrieElement(reader)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return trie, nil
+}
+
+func decodeTrieElement(reader trieReader) (trieElement, error) {
+	tag, err := reader.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	switch tag {
+	case trieTagNil:
+		return nil, nil
+	case trieTagNode:
+		var fields [2]byte
+		if _, err = io.ReadFull(reader, fields[:]); err != nil {
+			return nil, err
+		}
+		scansAnounced, err := binary.ReadVarint(reader)
+		if err != nil {
+			return nil, err
+		}
+		scansUnanounced, err := binary.ReadVarint(reader)
+		if err != nil {
+			return nil, err
+		}
+		var flags [4]byte
+		if _, err = io.ReadFull(reader, flags[:]); err != nil {
+			return nil, err
+		}
+		newNode := &node{
+			counterReturnedAsScope: fields[0],
+			nodeScans:              fields[1],
+			scansAnounced:          int(scansAnounced),
+			scansUnanounced:        int(scansUnanounced),
+			whichKindofPrefix:      flags[0],
+			hasBGPsubnet:           flags[1] == 1,
+			isAnnounced:            flags[2] == 1,
+			value:                  flags[3],
+		}
+		for i := range newNode.childs {
+			newNode.childs[i], err = decodeTrieElement(reader)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return newNode, nil
+	case trieTagLeaf:
+		leafScanned, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		scansAnnounced, err := binary.ReadVarint(reader)
+		if err != nil {
+			return nil, err
+		}
+		scansUnnanounced, err := binary.ReadVarint(reader)
+		if err != nil {
+			return nil, err
+		}
+		var flags [4]byte
+		if _, err = io.ReadFull(reader, flags[:]); err != nil {
+			return nil, err
+		}
+		return &leaf{
+			leafScanned:       leafScanned,
+			scansAnnounced:    int(scansAnnounced),
+			scansUnnanounced:  int(scansUnnanounced),
+			whichKindofPrefix: flags[0],
+			hasBGPsubnet:      flags[1] == 1,
+			value:             flags[2],
+			isAnnounced:       flags[3] == 1,
+		}, nil
+	}
+	return nil, errors.New("unknown trie element tag")
+}
+
+func boolToByte(b bool) byte {
+	if b {
+		return 1
+	}
+	return 0
+}
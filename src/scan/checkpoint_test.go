@@ -0,0 +1,138 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package scan
+
+import (
+	"encoding/gob"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The time budget of a domain continues on resume with what was left at the checkpoint
+func TestCheckpointTimeLeft(t *testing.T) {
+	scanner := newScriptedScanner(t, nil)
+	scanner.config.DomainTimeout = time.Hour
+	cpDomain := func(name string, left time.Duration) checkpointDomain {
+		return checkpointDomain{Domain: name, NameserverIP: net.IPv4(192, 0, 2, 53).To4(), NameserverPort: 53, Transport: TRANSPORT_UDP, Family: 1, TimeLeft: left}
+	}
+	cp := &Checkpoint{Domains: []checkpointDomain{
+		cpDomain("expired.example", -time.Second),
+		cpDomain("running.example", time.Minute),
+		cpDomain("new.example", 0),
+	}}
+	domains, _, err := scanner.restoreDomains(cp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !domains[0].timedOut() {
+		t.Error("the domain which ran out of its time budget has time left after resuming")
+	}
+	if left := time.Until(domains[1].deadline); left > time.Minute || left < 50*time.Second {
+		t.Errorf("expected a minute left, got %v", left)
+	}
+	if left := time.Until(domains[2].deadline); left < 59*time.Minute {
+		t.Errorf("expected the full time budget for a domain which did not start, got %v", left)
+	}
+
+	for _, domainState := range domains {
+		if left := timeLeft(domainState.deadline, time.Now()); (left < 0) != (domainState.domain == "expired.example") {
+			t.Errorf("%v: %v left at the next checkpoint", domainState.domain, left)
+		}
+	}
+}
+
+// Checkpoints of another format version are rejected instead of guessing the fields they lack
+func TestReadCheckpointVersion(t *testing.T) {
+	dir := t.TempDir()
+	for _, version := range []int{0, checkpointVersion + 1} {
+		f, err := os.Create(filepath.Join(dir, checkpointFileName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = gob.NewEncoder(f).Encode(&Checkpoint{Version: version, InputLine: 3})
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = ReadCheckpoint(dir)
+		if err == nil || !strings.Contains(err.Error(), "version") {
+			t.Errorf("checkpoint of version %v: expected a version error, got %v", version, err)
+		}
+	}
+}
+
+// Pending requests are restored as request lists or single queries as they were held, independent of the strategy
+func TestCheckpointPendingRequests(t *testing.T) {
+	scanner := newScriptedScanner(t, nil)
+	query := func(last byte) checkpointQuery {
+		return checkpointQuery{Address: net.IPv4(198, 51, 100, last).To4(), SourcePrefixLength: 24, Family: 1}
+	}
+	cp := &Checkpoint{Domains: []checkpointDomain{{
+		Domain:         "pending.example",
+		NameserverIP:   net.IPv4(192, 0, 2, 53).To4(),
+		NameserverPort: 53,
+		Transport:      TRANSPORT_UDP,
+		Family:         1,
+		Pending: []checkpointRequest{
+			{List: true, Queries: []checkpointQuery{query(1), query(2)}},
+			{Queries: []checkpointQuery{query(3)}},
+		},
+	}}}
+	domains, requests, err := scanner.restoreDomains(cp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 2 || requests[0].kind != RESULT_LIST || len(requests[0].queries) != 2 || requests[1].kind != RESULT_QUERY {
+		t.Fatalf("expected a request list of two queries and a single query, got %+v", requests)
+	}
+	if !requests[1].queries[0].ipAddressClient.Equal(net.IPv4(198, 51, 100, 3)) || requests[1].domainState != domains[0] {
+		t.Errorf("the single query was not restored, got %+v", requests[1].queries[0])
+	}
+	if domains[0].inFlight != 3 {
+		t.Errorf("expected 3 queries in flight, got %v", domains[0].inFlight)
+	}
+}
+
+// Only the finished domains past the input line are stored, the others are skipped with the input on resume
+func TestCheckpointFinishedWindow(t *testing.T) {
+	scanner := newScriptedScanner(t, nil)
+	var taken int64 = 5
+	checkpoints := newTestCheckpointer(t, scanner)
+	checkpoints.inputLine = func() int64 { return taken }
+	checkpoints.finished = []checkpointFinished{{Identifier: "resumed", InputLine: 7}}
+	for line, identifier := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		checkpoints.domainFinished(&domainState{identifier: identifier, inputLine: int64(line + 1)})
+	}
+	finished := func() []string {
+		cp, err := ReadCheckpoint(checkpoints.dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var identifiers []string
+		for _, cpFinished := range cp.Finished {
+			identifiers = append(identifiers, cpFinished.Identifier)
+		}
+		return identifiers
+	}
+
+	taken = 6
+	if err := checkpoints.write(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(finished(), ","); got != "resumed,g,h" {
+		t.Errorf("expected the domains of the lines 7 and 8 to be stored, got %v", got)
+	}
+	taken = 8
+	if err := checkpoints.write(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := finished(); len(got) != 0 || len(checkpoints.finished) != 0 {
+		t.Errorf("the finished domains were not dropped once the input line moved past them, got %v", got)
+	}
+}
@@ -0,0 +1,199 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package scan
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// Config is the configuration of a scan, the flags of the command line tool set the field of the same name.
+// Start from DefaultConfig, the zero value of most fields disables the feature instead of selecting its default.
+type Config struct {
+	// Client subnets
+	Strategy                string         // strategy choosing the client subnets, empty for list if QueryList is set and trie otherwise
+	IPv6                    bool           // scan with IPv6 client subnets instead of IPv4 ones
+	DualStack               bool           // scan every domain with IPv4 and IPv6 client subnets
+	PrefixLengthIPv4        int            // source prefix length of IPv4 client subnets
+	PrefixLengthIPv6        int            // source prefix length of IPv6 client subnets
+	LimitsIPv4              Limits         // limits of the trie strategy in the IPv4 address space
+	LimitsIPv6              Limits         // limits of the trie strategy in the IPv6 address space
+	BGPPrefixes             []netip.Prefix // announced prefixes of both families
+	SpecialPrefixes         []netip.Prefix // special use prefixes of both families, e.g. private address space
+	QueryList               []netip.Prefix // client subnets of the list strategy
+	RandomizeDepth          int            // prefix length from which the trie is walked in random order
+	ScanAllBGP              bool           // scan all announced prefixes even if the limits are hit
+	MaxScopeZeros           int            // responses with scope 0 after which a domain is finished, <= 0 for unlimited
+	MaxTempErrors           int            // temporary errors after which a domain is finished
+	DomainTimeout           time.Duration  // time after which a domain sends no more queries and is finished, 0 for unlimited
+	MaxQueriesPerDomain     int            // client subnets queried per domain and family, <= 0 for unlimited
+	MaxQueriesPerNameserver int            // client subnets queried per nameserver across all its domains, <= 0 for unlimited
+
+	// Queries
+	QueryType         uint16 // 0 to query A or AAAA depending on the family of the client subnet
+	Recursive         bool   // the nameservers are recursive resolvers, queries ask for recursion and answers need not be authoritative
+	Transport         string // transport of domains without one
+	NameserverPort    int    // port of nameservers without one, 0 for the default port of the transport
+	LocalAddress      net.IP // source address of the queries, nil to let the system choose
+	TLSServerName     string
+	TLSInsecure       bool
+	DoHPath           string
+	StreamPipeline    int
+	StreamIdleTimeout time.Duration
+	TimeoutDial       time.Duration
+	TimeoutRead       time.Duration
+	TimeoutWrite      time.Duration
+	Retries           int
+	TruncationRetries int
+	TCPFallback       bool
+	RetryBackoff      time.Duration
+	RetryBackoffCap   time.Duration
+	UDPSockets        int // sockets shared by all UDP queries, 0 to open a new socket for every query
+
+	// Rates
+	QueryRate      float64 // queries per second, <= 0 for unlimited
+	QueryBurst     int
+	Workers        int // queries in flight at once, 0 to derive it from the query rate
+	NSQueryRate    float64
+	NSQueryBurst   int
+	NSConcurrency  int
+	NSGroup        string
+	Pfx2AS         map[netip.Prefix]string // origin AS of announced prefixes, needed to group nameservers by ASN
+	Adaptive       bool
+	AdaptiveFloor  float64
+	AdaptiveErrors int
+
+	// Controller
+	ChannelCapacity   int
+	IPGenerators      int
+	DomainOutstanding int
+	PrintFinalResult  bool
+	StatsInterval     time.Duration // interval to log the statistics, 0 to disable
+	MaxDuration       time.Duration // time after which Run stops the scan like a cancelled context, 0 for unlimited
+
+	// Results and checkpoints
+	Sink               ResultSink
+	CheckpointDir      string        // directory of the checkpoints
+	CheckpointInterval time.Duration // 0 to write no checkpoints
+	ResumeFrom         *Checkpoint   // checkpoint to resume, the domains are read from the same input
+
+	// Logging
+	Logger *Logger // nil for the default logger of the command line tool, see Init_Logging
+}
+
+// Limits bound the scans of the trie strategy in one address family
+type Limits struct {
+	Announced             map[int]int // scans below a prefix of the given length in BGP announced space
+	Unannounced           map[int]int // scans below a prefix of the given length in unannounced space
+	Total                 map[int]int // scans below a prefix of the given length
+	MaxSpecialPrefixScans int
+	ScanResultsToFinish   uint8
+	TotalNotroutedLimit   int
+}
+
+// DefaultConfig returns the configuration the command line tool uses without flags
+func DefaultConfig() Config {
+	return Config{
+		PrefixLengthIPv4:  24,
+		PrefixLengthIPv6:  48,
+		RandomizeDepth:    32,
+		MaxScopeZeros:     10000,
+		MaxTempErrors:     3,
+		Transport:         TRANSPORT_UDP,
+		DoHPath:           "/dns-query",
+		StreamPipeline:    64,
+		StreamIdleTimeout: 5 * time.Second,
+		TimeoutDial:       2 * time.Second,
+		TimeoutRead:       2 * time.Second,
+		TimeoutWrite:      2 * time.Second,
+		Retries:           3,
+		TruncationRetries: 2,
+		TCPFallback:       true,
+		RetryBackoff:      100 * time.Millisecond,
+		RetryBackoffCap:   2 * time.Second,
+		UDPSockets:        4,
+		QueryRate:         100,
+		NSGroup:           NS_GROUP_IP,
+		AdaptiveFloor:     1,
+		AdaptiveErrors:    2,
+		ChannelCapacity:   100,
+		IPGenerators:      20,
+		DomainOutstanding: 100,
+		StatsInterval:     time.Minute,
+	}
+}
+
+// strategyName returns the strategy of the scan, resolving the empty default
+func (config *Config) strategyName() string {
+	if config.Strategy != "" {
+		return config.Strategy
+	}
+	if len(config.QueryList) > 0 {
+		return STRATEGY_LIST
+	}
+	return STRATEGY_TRIE
+}
+
+// UsesQueryList reports whether the strategy of the scan queries the prefixes of the query list.
+// Such a strategy needs no limits and learns no scope map.
+func (config *Config) UsesQueryList() bool {
+	strategy := generatorStrategies[config.strategyName()]
+	return strategy != nil && strategy.usesQueryList
+}
+
+// Validate returns an error if the scan can't be run with the configuration
+func (config *Config) Validate() error {
+	strategy := generatorStrategies[config.strategyName()]
+	if strategy == nil {
+		return fmt.Errorf("unknown strategy '%v', use %v", config.Strategy, strings.Join(StrategyNames(), ", "))
+	}
+	if strategy.usesQueryList && len(config.QueryList) == 0 {
+		return fmt.Errorf("the %v strategy needs a query list", config.strategyName())
+	}
+	if !strategy.usesQueryList && len(config.QueryList) > 0 {
+		return fmt.Errorf("the %v strategy does not use the query list", config.strategyName())
+	}
+	if config.DualStack && config.IPv6 {
+		return errors.New("a dual stack scan scans both families, IPv6 can't be selected as well")
+	}
+	if !config.IPv6 && (config.PrefixLengthIPv4 <= 0 || config.PrefixLengthIPv4 > 32) {
+		return fmt.Errorf("invalid IPv4 prefix length %v", config.PrefixLengthIPv4)
+	}
+	if (config.IPv6 || config.DualStack) && (config.PrefixLengthIPv6 <= 0 || config.PrefixLengthIPv6 > 128) {
+		return fmt.Errorf("invalid IPv6 prefix length %v", config.PrefixLengthIPv6)
+	}
+	for _, limits := range []Limits{config.LimitsIPv4, config.LimitsIPv6} {
+		for _, kind := range []map[int]int{limits.Announced, limits.Unannounced, limits.Total} {
+			for length := range kind {
+				if length < 0 || length > 128 {
+					return fmt.Errorf("limit of invalid prefix length %v", length)
+				}
+			}
+		}
+	}
+	if err := CheckTransport(config.Transport); err != nil {
+		return err
+	}
+	if config.DomainTimeout < 0 || config.MaxDuration < 0 {
+		return errors.New("the domain timeout and the maximum duration can't be negative")
+	}
+	if config.NameserverPort < 0 || config.NameserverPort > 65535 {
+		return fmt.Errorf("invalid nameserver port %v", config.NameserverPort)
+	}
+	if config.NSGroup != NS_GROUP_IP && config.NSGroup != NS_GROUP_PREFIX && config.NSGroup != NS_GROUP_ASN {
+		return fmt.Errorf("unknown nameserver group '%v', use ip, prefix or asn", config.NSGroup)
+	}
+	if config.NSGroup == NS_GROUP_ASN && len(config.Pfx2AS) == 0 {
+		return errors.New("grouping nameservers by ASN needs the origin AS of the announced prefixes")
+	}
+	if (config.CheckpointInterval > 0 || config.ResumeFrom != nil) && config.CheckpointDir == "" {
+		return errors.New("checkpoints need a directory")
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package scan
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+func (scanner *Scanner) printDomainResult(scannedDomain *domainState) {
+	scanner.config.Logger.debuglog("CONTROLLER:   Domain : %v", scannedDomain.domain)
+	if scanner.config.PrintFinalResult {
+		scanner.config.Logger.debuglog("	Scanned Domain: %v", scannedDomain.domain)
+		scanner.config.Logger.debuglog("      With element: %v", scannedDomain)
+	}
+}
+
+// scannerStarter starts the goroutines sending the requests of the controller, they report to the controller queue
+type scannerStarter func(ctx context.Context, requests <-chan *ipGeneratorResult, controllerQueue *ControllerQueue)
+
+// startScanners sends the requests through the nameserver scheduler, which keeps to the caps of the nameservers, to the scanner handlers
+func (scanner *Scanner) startScanners(ctx context.Context, requests <-chan *ipGeneratorResult, controllerQueue *ControllerQueue) {
+	scanner.nsScheduler = newNameserverScheduler(scanner, requests)
+	go scanner.nsScheduler.run(ctx)
+	for i := 0; i < scanner.config.Workers; i++ {
+		go scanner.scannerHandler(ctx, scanner.nsScheduler.output, controllerQueue)
+	}
+}
+
+/*
+Controller is responsible for taking a list of domain names that need to be scanned.
+The controller keeps track of the state of each domain and sends the state of a domain to the ipgenerator. The ipgenerator answers with the next EDNS-parameters.
+The controller then sends the ECS-parameters to the scannerHandler (a function to convert the request into the right format), that will forward it to the Scanner.
+After receiving the answer from the scanner via the receiveResponse function, the controller orders new EDNS-parameters from the ip generator. This repeats until scanning is finished.
+To write a checkpoint or to stop, the controller drains: it admits no new domains and holds back new queries until all outstanding queries and generator requests returned.
+The scan stops once Scanner.Stop is called, after the queries handed to the scanners returned. Once ctx is done it stops
+right away, the scanners then return the queries which were not answered yet and they are held back as well.
+The domains of a resumed scan are passed in resumedDomains together with the requests which were held back when the checkpoint was written.
+startScanners starts the scanners answering the requests sent to the channel, it is startScanners of the scanner outside of tests.
+*/
+func (scanner *Scanner) controller(nextDomainStates func() []*domainState, resumedDomains []*domainState, resumedRequests []*ipGeneratorResult, checkpoints *checkpointer, startScanners scannerStarter, ctx context.Context) {
+	scanner.config.Logger.debuglog("CONTROLLER:   Function was started.")
+
+	channelControllerToIPGenerator := make(chan *ipGeneratorRequest, scanner.config.ChannelCapacity)
+	channelControllerToScannerHandler := make(chan *ipGeneratorResult, scanner.config.ChannelCapacity)
+	scanner.config.Logger.debuglog("CONTROLLER:   All Channels are initialized.")
+
+	//create ControllerQueue that communicates the new requests from the IP Generator to the Controller and the completed scans from the scanner to the controller
+	var controllerQueue ControllerQueue
+	controllerQueue.condition = sync.NewCond(&sync.Mutex{})
+	controllerQueue.sliceIPGeneratorToController = make([]*ipGeneratorResult, 0, 1)
+	controllerQueue.sliceScannerToController = make([]*dnsResult, 0, 1)
+	scanner.config.Logger.debuglog("CONTROLLER:   The controllerQueue is initialized.")
+
+	controllerDone := make(chan struct{})
+	defer close(controllerDone)
+	go func() {
+		select {
+		case <-scanner.stop:
+			scanner.config.Logger.debuglog("CONTROLLER:   Stop was requested")
+			controllerQueue.requestStop()
+		case <-ctx.Done():
+			scanner.config.Logger.debuglog("CONTROLLER:   Stop was requested: %s", ctx.Err())
+			controllerQueue.requestStop()
+		case <-controllerDone:
+		}
+	}()
+	if checkpoints != nil && checkpoints.interval > 0 {
+		go func() {
+			ticker := time.NewTicker(checkpoints.interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					controllerQueue.requestCheckpoint()
+				case <-controllerDone:
+					return
+				}
+			}
+		}()
+	}
+
+	//create IP generators, scanner and scannerHandlers
+	for i := scanner.config.IPGenerators; i > 0; i-- {
+		go scanner.ipgenerator(channelControllerToIPGenerator, &controllerQueue)
+	}
+	startScanners(ctx, channelControllerToScannerHandler, &controllerQueue)
+	scanner.config.Logger.debuglog("CONTROLLER:   All IP Generators and the ScannerHandler is initialized.")
+
+	currentlyScannedDomains := make(map[string]*domainState) // map of all scanned Domains with their Domain+nameserverip as key and a pointer to their state as value.
+	outstandingQueries := 0                                  // number of requests handed to the scanners without a result yet
+	pendingGeneratorRequests := 0                            // number of requests handed to the ip generators without a result yet
+	var heldRequests []*ipGeneratorResult                    // requests created while draining, they are sent once the checkpoint is written
+
+	resumedWithRequest := make(map[*domainState]bool)
+	for _, request := range resumedRequests {
+		resumedWithRequest[request.domainState] = true
+		outstandingQueries++
+		channelControllerToScannerHandler <- request
+	}
+	for _, domainState := range resumedDomains {
+		currentlyScannedDomains[domainState.identifier] = domainState
+		if !resumedWithRequest[domainState] {
+			pendingGeneratorRequests++
+			channelControllerToIPGenerator <- &ipGeneratorRequest{domainState: domainState}
+		}
+	}
+	if len(resumedDomains) > 0 {
+		scanner.config.Logger.infolog("CONTROLLER:   Resumed %v domains with %v requests", len(resumedDomains), len(resumedRequests))
+	}
+
+	/*
+		For the given capacity of a channel, we start with that amount of domains in our scanning routines. For each of these domains
+		we send their state (currently quite empty as nothing has been scanned) to the channel to the IP Generator so that the IP
+		Generator can calculate the first parameters for the first scan of these domains. We also increment the waitinggroup for each of them
+	*/
+	var noMoreDomains = false
+
+	for !noMoreDomains || len(currentlyScannedDomains) > 0 {
+		draining := controllerQueue.stopRequested.Load() || controllerQueue.checkpointRequested.Load()
+		// add new requests to queue
+		for len(currentlyScannedDomains) < scanner.config.DomainOutstanding && !noMoreDomains && !draining {
+			domainStates := nextDomainStates()
+			if domainStates == nil {
+				noMoreDomains = true
+				scanner.config.Logger.debuglog("Controller: no more domains available to scan")
+			}
+			// the states of one line are started together, so a checkpoint never splits a line
+			for _, domainState := range domainStates {
+				currentlyScannedDomains[domainState.identifier] = domainState
+				scanner.stats.domainsStarted.Add(1)
+				newRequest := ipGeneratorRequest{
+					domainState: domainState,
+				}
+				scanner.config.Logger.debuglog("CONTROLLER: Request to IP Generator will be sent for %v ", domainState.domain)
+				pendingGeneratorRequests++
+				channelControllerToIPGenerator <- &newRequest
+			}
+		}
+
+		controllerQueue.condition.L.Lock()
+
+		stopping := controllerQueue.stopRequested.Load()
+		draining = stopping || controllerQueue.checkpointRequested.Load()
+		if draining && outstandingQueries == 0 && pendingGeneratorRequests == 0 {
+			// nothing is in flight, the domain states can be written
+			if checkpoints != nil {
+				err := checkpoints.write(currentlyScannedDomains, heldRequests)
+				if err != nil {
+					scanner.config.Logger.errorlog("CONTROLLER:   Could not write checkpoint: %s", err)
+				}
+			}
+			controllerQueue.condition.L.Unlock()
+			if stopping {
+				break
+			}
+			controllerQueue.checkpointRequested.Store(false)
+			for _, request := range heldRequests {
+				outstandingQueries++
+				channelControllerToScannerHandler <- request
+			}
+			heldRequests = nil
+			continue
+		}
+		if len(controllerQueue.sliceScannerToController) == 0 && len(controllerQueue.sliceIPGeneratorToController) == 0 && (!noMoreDomains || len(currentlyScannedDomains) > 0) {
+			// if no new request or response is there wait for one but only wait if there is something to wait for
+			controllerQueue.condition.Wait()
+		}
+		scanner.config.Logger.debuglog("Controller: ipgenlen %v", len(controllerQueue.sliceIPGeneratorToController))
+		scanner.config.Logger.debuglog("Controller: scan_resultslen %v", len(controllerQueue.sliceScannerToController))
+		scanner.stats.outstandingDomains.Store(int64(len(currentlyScannedDomains)))
+		scanner.stats.ipGeneratorBacklog.Store(int64(len(controllerQueue.sliceIPGeneratorToController)))
+		scanner.stats.scannerBacklog.Store(int64(len(controllerQueue.sliceScannerToController)))
+		if len(controllerQueue.sliceIPGeneratorToController) > 0 {
+			// Process new request
+			newRequest := controllerQueue.sliceIPGeneratorToController[0]
+			controllerQueue.sliceIPGeneratorToController = controllerQueue.sliceIPGeneratorToController[1:] //we receive new request parameters from an IP generator
+			pendingGeneratorRequests--
+
+			domainState := newRequest.domainState
+			switch newRequest.kind {
+			case RESULT_FINISHED:
+				scanner.config.Logger.debuglog("CONTROLLER:   We have finished scanning for Domain %v ", domainState.domain)
+				scanner.printDomainResult(domainState)
+				delete(currentlyScannedDomains, domainState.identifier)
+				scanner.stats.domainFinished(domainState.finishReason)
+				if checkpoints != nil {
+					checkpoints.domainFinished(domainState)
+				}
+			case RESULT_WAITING:
+				scanner.config.Logger.debuglog("CONTROLLER:   Waiting for more results for %v", domainState.domain)
+			case RESULT_QUERY, RESULT_LIST:
+				if draining {
+					scanner.config.Logger.debuglog("CONTROLLER:   Holding back %v queries of %v while draining", len(newRequest.queries), domainState.domain)
+					heldRequests = append(heldRequests, newRequest)
+					break
+				}
+				if newRequest.kind == RESULT_LIST {
+					scanner.config.Logger.debuglog("CONTROLLER:   Sending Request list with len %v", len(newRequest.queries))
+				} else {
+					scanner.config.Logger.debuglog("CONTROLLER:   IPGen sent us: Domain = %v , IP = %v / %v ", domainState.domain, newRequest.queries[0].ipAddressClient, newRequest.queries[0].sourcePrefixLength)
+				}
+				outstandingQueries++
+				channelControllerToScannerHandler <- newRequest
+			}
+		}
+		if len(controllerQueue.sliceScannerToController) > 0 {
+			// Process new result
+			newCompletedScan := controllerQueue.sliceScannerToController[0]
+			controllerQueue.sliceScannerToController = controllerQueue.sliceScannerToController[1:]
+			outstandingQueries--
+			for _, queryResponseObj := range newCompletedScan.responses {
+				if scanner.isPermanent(queryResponseObj.error) {
+					queryResponseObj.request.domainState.permError = true
+				}
+				if queryResponseObj.error != 0 {
+					queryResponseObj.request.domainState.tempErrors++
+				}
+				scanner.config.Logger.debuglog("CONTROLLER:   Scanner sent us: domain = %v , ClientIP = %v / %v Scope PL = %v", queryResponseObj.request.domainState.domain, queryResponseObj.request.ipAddressClient, queryResponseObj.request.sourcePrefixLength, queryResponseObj.scopePrefixLength)
+			}
+
+			if newCompletedScan.unsent != nil {
+				// the scan was cancelled before the queries were answered, they are sent again on resume
+				heldRequests = append(heldRequests, newCompletedScan.unsent)
+			}
+			if len(newCompletedScan.responses) > 0 || newCompletedScan.expired > 0 {
+				pendingGeneratorRequests++
+				channelControllerToIPGenerator <- &ipGeneratorRequest{
+					domainState: newCompletedScan.domainState,
+					lastScans:   newCompletedScan.responses,
+					expired:     newCompletedScan.expired,
+				}
+			}
+		}
+
+		controllerQueue.condition.L.Unlock()
+	}
+	if controllerQueue.stopRequested.Load() {
+		scanner.stats.domainsAborted.Add(int64(len(currentlyScannedDomains)))
+		scanner.config.Logger.infolog("CONTROLLER:   Scan was stopped with %v domains outstanding", len(currentlyScannedDomains))
+	}
+	scanner.config.Logger.debuglog("CONTROLLER:   We will now close all channels")
+	close(channelControllerToIPGenerator)
+	close(channelControllerToScannerHandler)
+	scanner.config.Logger.debuglog("CONTROLLER:   We have closed all channels")
+}
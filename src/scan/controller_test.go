@@ -0,0 +1,386 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package scan
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+)
+
+const strategyScripted = "scripted"
+
+func init() {
+	registerStrategy(strategyScripted, &generatorStrategy{newGenerator: newScriptedGenerator})
+}
+
+// scriptStep is an answer of the scripted generator, a request list of the given length or a single query
+type scriptStep struct {
+	kind    resultKind
+	queries int
+}
+
+var (
+	scriptsMutex sync.Mutex
+	scripts      map[string][]scriptStep       // steps of the generator of each domain, set by the test
+	generators   map[string]*scriptedGenerator // generators created for each domain
+)
+
+// scriptedGenerator answers Next with the steps of the script of its domain one after another and finishes afterwards
+type scriptedGenerator struct {
+	domainState *domainState
+	script      []scriptStep
+	created     int
+	consumed    []*queryResponse
+}
+
+func newScriptedGenerator(domainState *domainState) subnetGenerator {
+	scriptsMutex.Lock()
+	defer scriptsMutex.Unlock()
+	generator := &scriptedGenerator{domainState: domainState, script: scripts[domainState.domain]}
+	generators[domainState.domain] = generator
+	return generator
+}
+
+func (generator *scriptedGenerator) Consume(response *queryResponse) {
+	generator.consumed = append(generator.consumed, response)
+}
+
+func (generator *scriptedGenerator) Next() *ipGeneratorResult {
+	domainState := generator.domainState
+	if len(generator.script) == 0 {
+		domainState.finishReason = FINISHED_TRIE_EXHAUSTED
+		return domainScanFinished(domainState)
+	}
+	step := generator.script[0]
+	generator.script = generator.script[1:]
+	switch step.kind {
+	case RESULT_QUERY:
+		return singleQuery(generator.query())
+	case RESULT_LIST:
+		var queries []*queryRequest
+		for i := 0; i < step.queries; i++ {
+			queries = append(queries, generator.query())
+		}
+		return queryRequestList(domainState, queries)
+	}
+	return waitingForMoreResults(domainState)
+}
+
+func (generator *scriptedGenerator) query() *queryRequest {
+	generator.created++
+	return &queryRequest{
+		ipAddressClient:    net.IPv4(198, 51, 100, byte(generator.created)).To4(),
+		sourcePrefixLength: 24,
+		family:             1,
+		domainState:        generator.domainState,
+	}
+}
+
+type discardSink struct{}
+
+func (discardSink) WriteResult(*Result) error         { return nil }
+func (discardSink) WriteScopeMap([]ScopePrefix) error { return nil }
+
+// fakeScanners answers the requests of the controller one after another with answer instead of sending queries
+type fakeScanners struct {
+	answer   func(ctx context.Context, request *ipGeneratorResult, controllerQueue *ControllerQueue) *dnsResult
+	received []*ipGeneratorResult
+}
+
+func (scanners *fakeScanners) start(ctx context.Context, requests <-chan *ipGeneratorResult, controllerQueue *ControllerQueue) {
+	go func() {
+		for request := range requests {
+			scanners.received = append(scanners.received, request)
+			controllerQueue.addScanResult(scanners.answer(ctx, request, controllerQueue))
+		}
+	}()
+}
+
+// answerAll answers every query of a request with scope 24
+func answerAll(_ context.Context, request *ipGeneratorResult, _ *ControllerQueue) *dnsResult {
+	return &dnsResult{domainState: request.domainState, responses: answer(request.queries)}
+}
+
+func answer(queries []*queryRequest) []*queryResponse {
+	var responses []*queryResponse
+	for _, query := range queries {
+		responses = append(responses, &queryResponse{request: query, scopePrefixLength: 24, error: NO_ERR})
+	}
+	return responses
+}
+
+func newScriptedScanner(t *testing.T, domainScripts map[string][]scriptStep) *Scanner {
+	t.Helper()
+	scriptsMutex.Lock()
+	scripts = domainScripts
+	generators = make(map[string]*scriptedGenerator)
+	scriptsMutex.Unlock()
+
+	config := DefaultConfig()
+	config.Strategy = strategyScripted
+	config.Sink = discardSink{}
+	scanner, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return scanner
+}
+
+// testDomains returns the domain states of the names one after another, all on the same nameserver
+func testDomains(scanner *Scanner, names ...string) func() []*domainState {
+	return func() []*domainState {
+		if len(names) == 0 {
+			return nil
+		}
+		name := names[0]
+		names = names[1:]
+		return scanner.domainStates(Domain{Name: name, Nameserver: net.IPv4(192, 0, 2, 53)}, nil)
+	}
+}
+
+func newTestCheckpointer(t *testing.T, scanner *Scanner) *checkpointer {
+	return &checkpointer{scanner: scanner, dir: t.TempDir(), inputLine: func() int64 { return 0 }}
+}
+
+func TestControllerSendsQueriesAndLists(t *testing.T) {
+	scanner := newScriptedScanner(t, map[string][]scriptStep{
+		"single.example": {{kind: RESULT_QUERY}, {kind: RESULT_QUERY}},
+		"list.example":   {{kind: RESULT_LIST, queries: 3}, {kind: RESULT_QUERY}},
+	})
+	scanners := &fakeScanners{answer: answerAll}
+	scanner.controller(testDomains(scanner, "single.example", "list.example"), nil, nil, nil, scanners.start, context.Background())
+
+	stats := scanner.Statistics()
+	if stats.DomainsFinished != 2 || stats.DomainsFinishedByReason["trieExhausted"] != 2 || stats.DomainsAborted != 0 {
+		t.Fatalf("expected both domains to be finished, got %+v", stats)
+	}
+	sent := map[string][]resultKind{}
+	for _, request := range scanners.received {
+		if request.kind == RESULT_LIST && len(request.queries) != 3 {
+			t.Errorf("request list of %v has %v queries, expected 3", request.domainState.domain, len(request.queries))
+		}
+		sent[request.domainState.domain] = append(sent[request.domainState.domain], request.kind)
+	}
+	expected := map[string][]resultKind{
+		"single.example": {RESULT_QUERY, RESULT_QUERY},
+		"list.example":   {RESULT_LIST, RESULT_QUERY},
+	}
+	for domain, kinds := range expected {
+		if len(sent[domain]) != len(kinds) {
+			t.Fatalf("%v: sent %v, expected %v", domain, sent[domain], kinds)
+		}
+		for i := range kinds {
+			if sent[domain][i] != kinds[i] {
+				t.Errorf("%v: sent %v, expected %v", domain, sent[domain], kinds)
+			}
+		}
+	}
+	if consumed := len(generators["single.example"].consumed); consumed != 2 {
+		t.Errorf("single.example consumed %v responses, expected 2", consumed)
+	}
+	if consumed := len(generators["list.example"].consumed); consumed != 4 {
+		t.Errorf("list.example consumed %v responses, expected 4", consumed)
+	}
+}
+
+// The scan is stopped while a request list is sent: the answered query goes to the generator, which waits for the others,
+// and the unsent queries are held back and written to the checkpoint.
+func TestControllerStopHoldsUnsentQueries(t *testing.T) {
+	scanner := newScriptedScanner(t, map[string][]scriptStep{
+		"list.example": {{kind: RESULT_LIST, queries: 3}, {kind: RESULT_WAITING}},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	scanners := &fakeScanners{answer: func(_ context.Context, request *ipGeneratorResult, _ *ControllerQueue) *dnsResult {
+		cancel()
+		return &dnsResult{
+			domainState: request.domainState,
+			responses:   answer(request.queries[:1]),
+			unsent:      queryRequestList(request.domainState, request.queries[1:]),
+		}
+	}}
+	checkpoints := newTestCheckpointer(t, scanner)
+	scanner.controller(testDomains(scanner, "list.example"), nil, nil, checkpoints, scanners.start, ctx)
+
+	stats := scanner.Statistics()
+	if stats.DomainsFinished != 0 || stats.DomainsAborted != 1 {
+		t.Fatalf("expected the domain to be aborted, got %+v", stats)
+	}
+	if len(scanners.received) != 1 {
+		t.Errorf("sent %v requests, expected 1", len(scanners.received))
+	}
+	if consumed := len(generators["list.example"].consumed); consumed != 1 {
+		t.Errorf("consumed %v responses, expected 1", consumed)
+	}
+	cp, err := ReadCheckpoint(checkpoints.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cp.Domains) != 1 || len(cp.Domains[0].Pending) != 1 {
+		t.Fatalf("expected one domain with one pending request in the checkpoint, got %+v", cp.Domains)
+	}
+	pending := cp.Domains[0].Pending[0]
+	if !pending.List || len(pending.Queries) != 2 || !pending.Queries[0].Address.Equal(net.IPv4(198, 51, 100, 2)) {
+		t.Errorf("expected the last two queries of the list to be pending, got %+v", pending)
+	}
+}
+
+// A checkpoint is requested while a query is outstanding: the next query of the domain is held back until the checkpoint
+// is written and sent afterwards.
+func TestControllerCheckpointHoldsBackQueries(t *testing.T) {
+	scanner := newScriptedScanner(t, map[string][]scriptStep{
+		"single.example": {{kind: RESULT_QUERY}, {kind: RESULT_QUERY}, {kind: RESULT_QUERY}},
+	})
+	scanners := &fakeScanners{answer: func(ctx context.Context, request *ipGeneratorResult, controllerQueue *ControllerQueue) *dnsResult {
+		if request.queries[0].ipAddressClient[3] == 1 {
+			controllerQueue.requestCheckpoint()
+		}
+		return answerAll(ctx, request, controllerQueue)
+	}}
+	checkpoints := newTestCheckpointer(t, scanner)
+	scanner.controller(testDomains(scanner, "single.example"), nil, nil, checkpoints, scanners.start, context.Background())
+
+	stats := scanner.Statistics()
+	if stats.DomainsFinished != 1 {
+		t.Fatalf("expected the domain to be finished, got %+v", stats)
+	}
+	if len(scanners.received) != 3 {
+		t.Errorf("sent %v requests, expected 3", len(scanners.received))
+	}
+	cp, err := ReadCheckpoint(checkpoints.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cp.Domains) != 1 || len(cp.Domains[0].Pending) != 1 {
+		t.Fatalf("expected one domain with one pending request in the checkpoint, got %+v", cp.Domains)
+	}
+	pending := cp.Domains[0].Pending[0]
+	if pending.List || len(pending.Queries) != 1 || !pending.Queries[0].Address.Equal(net.IPv4(198, 51, 100, 2)) {
+		t.Errorf("expected the second query to be pending, got %+v", pending)
+	}
+}
+
+// Queries of a domain waiting for the rate cap of its nameserver are dropped once the domain ran out of its time budget
+func TestControllerDropsQueriesAfterDomainTimeout(t *testing.T) {
+	scanner := newScriptedScanner(t, map[string][]scriptStep{
+		"slow.example": {{kind: RESULT_LIST, queries: 20}, {kind: RESULT_QUERY}},
+	})
+	scanner.config.DomainTimeout = 250 * time.Millisecond
+	scanner.config.NSQueryRate = 10
+	scanner.config.NSQueryBurst = 1
+	err := scanner.start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer scanner.closeTransports()
+	server := netip.MustParseAddrPort(newUDPServer(t))
+	domain := Domain{Name: "slow.example", Nameserver: server.Addr().AsSlice(), Port: int(server.Port())}
+	taken := false
+	domains := func() []*domainState {
+		if taken {
+			return nil
+		}
+		taken = true
+		return scanner.domainStates(domain, nil)
+	}
+	scanner.controller(domains, nil, nil, nil, scanner.startScanners, context.Background())
+
+	stats := scanner.Statistics()
+	if stats.DomainsFinished != 1 || stats.DomainsFinishedByReason["domainTimeout"] != 1 {
+		t.Fatalf("expected the domain to be finished by its time budget, got %+v", stats)
+	}
+	if stats.QueriesSent < 1 || stats.QueriesSent > 5 {
+		t.Errorf("sent %v of 20 queries in 250ms at 10 queries per second", stats.QueriesSent)
+	}
+	if consumed := len(generators["slow.example"].consumed); int64(consumed) != stats.QueriesSent {
+		t.Errorf("consumed %v responses of %v queries", consumed, stats.QueriesSent)
+	}
+}
+
+// Stop lets the scanners answer the queries they got, only the queries generated afterwards are held back
+func TestControllerStopWaitsForQueries(t *testing.T) {
+	scanner := newScriptedScanner(t, map[string][]scriptStep{
+		"list.example": {{kind: RESULT_LIST, queries: 3}, {kind: RESULT_QUERY}},
+	})
+	scanners := &fakeScanners{answer: func(ctx context.Context, request *ipGeneratorResult, controllerQueue *ControllerQueue) *dnsResult {
+		scanner.Stop()
+		for !controllerQueue.stopRequested.Load() {
+			time.Sleep(time.Millisecond)
+		}
+		if ctx.Err() != nil {
+			t.Error("the queries in flight were abandoned")
+		}
+		return answerAll(ctx, request, controllerQueue)
+	}}
+	checkpoints := newTestCheckpointer(t, scanner)
+	scanner.controller(testDomains(scanner, "list.example"), nil, nil, checkpoints, scanners.start, context.Background())
+
+	stats := scanner.Statistics()
+	if stats.DomainsFinished != 0 || stats.DomainsAborted != 1 {
+		t.Fatalf("expected the domain to be aborted, got %+v", stats)
+	}
+	if consumed := len(generators["list.example"].consumed); consumed != 3 {
+		t.Errorf("consumed %v responses, expected the 3 of the list", consumed)
+	}
+	cp, err := ReadCheckpoint(checkpoints.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cp.Domains) != 1 || len(cp.Domains[0].Pending) != 1 || cp.Domains[0].Pending[0].List {
+		t.Fatalf("expected the query generated after the stop to be pending, got %+v", cp.Domains)
+	}
+}
+
+// A nameserver which does not answer drives the adaptive rate of its group to the floor, afterwards its timeouts
+// count as temporary errors of the domain instead of aborting it as a permanent error
+func TestControllerAdaptiveFloorErrorsAreTemporary(t *testing.T) {
+	scanner := newScriptedScanner(t, map[string][]scriptStep{
+		"silent.example": {{kind: RESULT_QUERY}, {kind: RESULT_QUERY}, {kind: RESULT_QUERY}},
+	})
+	scanner.config.Adaptive = true
+	scanner.config.AdaptiveErrors = 1
+	scanner.config.AdaptiveFloor = 10
+	scanner.config.NSQueryRate = 40
+	scanner.config.Retries = 0
+	scanner.config.TimeoutRead = 50 * time.Millisecond
+	err := scanner.start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer scanner.closeTransports()
+	silent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	server := netip.MustParseAddrPort(silent.LocalAddr().String())
+	domain := Domain{Name: "silent.example", Nameserver: server.Addr().AsSlice(), Port: int(server.Port())}
+	taken := false
+	domains := func() []*domainState {
+		if taken {
+			return nil
+		}
+		taken = true
+		return scanner.domainStates(domain, nil)
+	}
+	scanner.controller(domains, nil, nil, nil, scanner.startScanners, context.Background())
+
+	stats := scanner.Statistics()
+	// 40 to 20 to 10 queries per second, the first query is repeated until the floor is reached
+	if stats.Backoffs != 2 || stats.RepeatedQueries != 2 {
+		t.Errorf("expected 2 backoffs and 2 repeated queries, got %v and %v", stats.Backoffs, stats.RepeatedQueries)
+	}
+	domainState := generators["silent.example"].domainState
+	if domainState.permError || domainState.tempErrors != 3 {
+		t.Errorf("expected 3 temporary errors and no permanent one, got %v and %v", domainState.tempErrors, domainState.permError)
+	}
+	if consumed := len(generators["silent.example"].consumed); consumed != 3 {
+		t.Errorf("consumed %v responses, expected 3", consumed)
+	}
+}
@@ -0,0 +1,154 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package scan
+
+import (
+	"cmp"
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"strconv"
+)
+
+// uint128 is an IPv6 address, or an IPv4 address in the upper 32 bits, so bit i is the i-th bit of the address
+type uint128 struct {
+	hi uint64
+	lo uint64
+}
+
+func uint128FromBytes(address []byte) uint128 {
+	if len(address) == net.IPv4len {
+		return uint128{hi: uint64(binary.BigEndian.Uint32(address)) << 32}
+	}
+	return uint128{hi: binary.BigEndian.Uint64(address[:8]), lo: binary.BigEndian.Uint64(address[8:16])}
+}
+
+func uint128FromAddr(addr netip.Addr) uint128 {
+	return uint128FromBytes(addr.AsSlice())
+}
+
+// bit returns the i-th bit, counted from the most significant one
+func (address uint128) bit(i int) uint8 {
+	if i < 64 {
+		return uint8(address.hi>>(63-i)) & 1
+	}
+	return uint8(address.lo>>(127-i)) & 1
+}
+
+// withBit returns the address with the i-th bit set if value is 1
+func (address uint128) withBit(i int, value uint8) uint128 {
+	if value == 0 {
+		return address
+	}
+	if i < 64 {
+		address.hi |= 1 << (63 - i)
+	} else {
+		address.lo |= 1 << (127 - i)
+	}
+	return address
+}
+
+// mask returns the first length bits of the address followed by zeros
+func (address uint128) mask(length int) uint128 {
+	if length < 64 {
+		return uint128{hi: address.hi &^ (^uint64(0) >> length)}
+	}
+	if length < 128 {
+		return uint128{hi: address.hi, lo: address.lo &^ (^uint64(0) >> (length - 64))}
+	}
+	return address
+}
+
+func (address uint128) compare(other uint128) int {
+	if address.hi != other.hi {
+		return cmp.Compare(address.hi, other.hi)
+	}
+	return cmp.Compare(address.lo, other.lo)
+}
+
+// ipPrefix is a prefix of a client address packed into 128 bits, the bits after length are 0.
+// It is passed by value, so walking the trie does not allocate.
+type ipPrefix struct {
+	address uint128
+	length  int
+}
+
+// prefixFromNetIP returns the first length bits of an address
+func prefixFromNetIP(ip net.IP, length int, isIPv6 bool) ipPrefix {
+	if isIPv6 {
+		ip = ip.To16()
+	} else {
+		ip = ip.To4()
+	}
+	return ipPrefix{address: uint128FromBytes(ip).mask(length), length: length}
+}
+
+// prefixFromField packs a prefix given as one byte per bit, as stored in checkpoints
+func prefixFromField(ipAsField []uint8) ipPrefix {
+	var prefix ipPrefix
+	for _, bit := range ipAsField {
+		prefix = prefix.child(bit)
+	}
+	return prefix
+}
+
+// field returns the prefix as one byte per bit
+func (prefix ipPrefix) field() []uint8 {
+	ipAsField := make([]uint8, prefix.length)
+	for i := range ipAsField {
+		ipAsField[i] = prefix.bit(i)
+	}
+	return ipAsField
+}
+
+// child returns the prefix extended by one bit
+func (prefix ipPrefix) child(value uint8) ipPrefix {
+	return ipPrefix{address: prefix.address.withBit(prefix.length, value), length: prefix.length + 1}
+}
+
+// truncate returns the first length bits of the prefix
+func (prefix ipPrefix) truncate(length int) ipPrefix {
+	return ipPrefix{address: prefix.address.mask(length), length: length}
+}
+
+func (prefix ipPrefix) bit(i int) uint8 {
+	return prefix.address.bit(i)
+}
+
+// compare orders prefixes by their network address, shorter prefixes first
+func (prefix ipPrefix) compare(other ipPrefix) int {
+	if c := prefix.address.compare(other.address); c != 0 {
+		return c
+	}
+	return cmp.Compare(prefix.length, other.length)
+}
+
+// netIP returns the network address of the prefix
+func (prefix ipPrefix) netIP(isIPv6 bool) net.IP {
+	if !isIPv6 {
+		ip := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(ip, uint32(prefix.address.hi>>32))
+		return ip
+	}
+	ip := make(net.IP, net.IPv6len)
+	binary.BigEndian.PutUint64(ip[:8], prefix.address.hi)
+	binary.BigEndian.PutUint64(ip[8:], prefix.address.lo)
+	return ip
+}
+
+// format returns the prefix in CIDR notation
+func (prefix ipPrefix) format(isIPv6 bool) string {
+	return prefix.netIP(isIPv6).String() + "/" + strconv.Itoa(prefix.length)
+}
+
+func (prefix ipPrefix) netipPrefix(isIPv6 bool) netip.Prefix {
+	address, _ := netip.AddrFromSlice(prefix.netIP(isIPv6))
+	return netip.PrefixFrom(address, prefix.length)
+}
+
+// formatPrefix returns a prefix in CIDR notation like format, IPv4-mapped IPv6 prefixes are written like IPv4 ones
+func formatPrefix(prefix netip.Prefix) string {
+	return net.IP(prefix.Addr().AsSlice()).String() + "/" + strconv.Itoa(prefix.Bits())
+}
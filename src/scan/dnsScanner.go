@@ -0,0 +1,459 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package scan
+
+import (
+	"context"
+	"fmt"
+	"github.com/miekg/dns"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// scannerHandler sends the queries handed out by the scheduler. Once ctx is done, queries are no longer sent
+// and those in flight are abandoned, both are returned to the controller as unsent.
+// Queries of a domain which ran out of its time budget while they waited are dropped without sending them.
+func (scanner *Scanner) scannerHandler(ctx context.Context, requestChan <-chan *scheduledRequest, controllerQueue *ControllerQueue) { //scannerHandler simulates a scanner
+	for scheduled := range requestChan {
+		if scheduled == nil {
+			break
+		}
+		if scheduled.request.kind == RESULT_LIST {
+			scanner.scanRequestList(ctx, scheduled, scheduled.request, controllerQueue)
+		} else {
+			scanner.scanRequest(ctx, scheduled, scheduled.request.queries[0], controllerQueue)
+		}
+	}
+}
+
+// scanRequest sends a single query and reports its response to the controller
+func (scanner *Scanner) scanRequest(ctx context.Context, scheduled *scheduledRequest, request *queryRequest, controllerQueue *ControllerQueue) {
+	scanner.config.Logger.debuglog("scannerHandler received request for %v with %v / %v", request.domainState.domain, request.ipAddressClient, request.sourcePrefixLength)
+
+	result := dnsResult{domainState: request.domainState}
+	var response *queryResponse
+	if scanner.waitForToken(ctx) == nil {
+		if request.domainState.timedOut() {
+			result.expired = 1
+		} else {
+			scanner.stats.queriesSent.Add(1)
+			response = scanner.performQuery(ctx, request)
+		}
+	}
+	if result.expired > 0 {
+		scanner.config.Logger.debuglog("scannerHandler dropping the query for %v as the domain ran out of its time budget", request.domainState.domain)
+	} else if response == nil {
+		scanner.config.Logger.debuglog("scannerHandler returning the query for %v unsent as the scan was cancelled", request.domainState.domain)
+		result.unsent = scheduled.request
+	} else if scheduled.repeatAfterBackoff(response.error) {
+		scanner.config.Logger.debuglog("scannerHandler repeating the query for %v after backing off", request.domainState.domain)
+		scanner.nsScheduler.repeat(scheduled)
+		return
+	} else {
+		scanner.writeResult(response)
+		result.responses = []*queryResponse{response}
+	}
+	scanner.nsScheduler.done(scheduled)
+	controllerQueue.addScanResult(&result)
+}
+
+// scanRequestList sends the queries of a list one after another and reports their responses to the controller at once
+func (scanner *Scanner) scanRequestList(ctx context.Context, scheduled *scheduledRequest, request *ipGeneratorResult, controllerQueue *ControllerQueue) {
+	scanner.config.Logger.debuglog("scannerHandler received request list of domains with length %v", len(request.queries))
+
+	resultObj := dnsResult{domainState: request.domainState}
+	for i, queryRequest := range request.queries {
+		var result *queryResponse
+		scheduled.repeats = 0
+		for repeat := true; repeat; repeat = result != nil && scheduled.repeatAfterBackoff(result.error) {
+			result = nil
+			if scheduled.waitForNameserverToken(ctx) != nil || scanner.waitForToken(ctx) != nil || request.domainState.timedOut() {
+				break
+			}
+			scanner.stats.queriesSent.Add(1)
+			result = scanner.performQuery(ctx, queryRequest)
+		}
+		if result == nil && ctx.Err() == nil {
+			scanner.config.Logger.debuglog("scannerHandler dropping the rest of the request list as %v ran out of its time budget", request.domainState.domain)
+			resultObj.expired = len(request.queries) - i
+			break
+		}
+		if result == nil {
+			scanner.config.Logger.debuglog("scannerHandler returning the rest of the request list unsent as the scan was cancelled")
+			resultObj.unsent = queryRequestList(request.domainState, request.queries[i:])
+			break
+		}
+		scanner.writeResult(result)
+		resultObj.responses = append(resultObj.responses, result)
+	}
+	scanner.nsScheduler.done(scheduled)
+	controllerQueue.addScanResult(&resultObj)
+}
+
+// waitForToken takes a token from the rate limiter and counts the time spent waiting for it
+func (scanner *Scanner) waitForToken(ctx context.Context) error {
+	wait, err := scanner.limiter.wait(ctx)
+	if wait > 0 {
+		scanner.stats.limiterWaits.Add(1)
+		scanner.stats.limiterWaitTime.Add(int64(wait))
+	}
+	return err
+}
+
+// ParseQueryType returns the RR type of a name like AAAA or HTTPS, unknown types can be given as e.g. TYPE65
+func ParseQueryType(name string) (uint16, error) {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	if qtype, ok := dns.StringToType[name]; ok {
+		return qtype, nil
+	}
+	if number, found := strings.CutPrefix(name, "TYPE"); found {
+		qtype, err := strconv.ParseUint(number, 10, 16)
+		if err == nil {
+			return uint16(qtype), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown query type '%v'", name)
+}
+
+// ParseNameserver parses a nameserver given as ip, ip:port or [ipv6]:port, the port is 0 if it is missing
+func ParseNameserver(nameserver string) (net.IP, int, error) {
+	host, port := nameserver, 0
+	if strings.HasPrefix(nameserver, "[") && strings.HasSuffix(nameserver, "]") {
+		host = nameserver[1 : len(nameserver)-1]
+	} else if strings.HasPrefix(nameserver, "[") || strings.Count(nameserver, ":") == 1 {
+		var portString string
+		var err error
+		host, portString, err = net.SplitHostPort(nameserver)
+		if err != nil {
+			return nil, 0, err
+		}
+		port, err = strconv.Atoi(portString)
+		if err != nil || port < 1 || port > 65535 {
+			return nil, 0, fmt.Errorf("invalid nameserver port '%v'", portString)
+		}
+	}
+	nameserverIP := net.ParseIP(host)
+	if nameserverIP == nil {
+		return nil, 0, fmt.Errorf("could not parse nameserver IP %v", host)
+	}
+	if nameserverIP.To4() != nil {
+		nameserverIP = nameserverIP.To4()
+	}
+	return nameserverIP, port, nil
+}
+
+// nameserverPort returns the port to query, the port given with the nameserver, the port of the config or the default port of the transport
+func (scanner *Scanner) nameserverPort(port int, transport string) int {
+	if port != 0 {
+		return port
+	}
+	if scanner.config.NameserverPort != 0 {
+		return scanner.config.NameserverPort
+	}
+	return transportPorts[transport]
+}
+
+func (scanner *Scanner) createDNSMessage(request *queryRequest) *dns.Msg {
+	qname := dns.Fqdn(request.domainState.domain)
+
+	qtype := request.domainState.qtype // Type to be queried, e.g. A,
+	if qtype == 0 {
+		if request.family == 1 {
+			qtype = dns.TypeA
+		} else {
+			qtype = dns.TypeAAAA
+		}
+	}
+	qclass := uint16(dns.ClassINET)
+
+	msg := &dns.Msg{
+		MsgHdr: dns.MsgHdr{
+			Authoritative:     false,
+			AuthenticatedData: false,
+			CheckingDisabled:  false,
+			RecursionDesired:  scanner.config.Recursive,
+			Opcode:            dns.OpcodeQuery,
+			Rcode:             dns.RcodeSuccess,
+		},
+		Question: make([]dns.Question, 1),
+	}
+	optrr := &dns.OPT{
+		Hdr: dns.RR_Header{
+			Name:   ".",
+			Rrtype: dns.TypeOPT,
+		},
+	}
+	nsid_option := &dns.EDNS0_NSID{
+		Code: dns.EDNS0NSID,
+	}
+	optrr.Option = append(optrr.Option, nsid_option)
+	// NSD will not return nsid when the udp message size is too small
+	optrr.SetUDPSize(dns.DefaultMsgSize)
+	ecs_option := &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		Address:       request.ipAddressClient,
+		Family:        uint16(request.family),
+		SourceNetmask: request.sourcePrefixLength,
+	}
+
+	optrr.Option = append(optrr.Option, ecs_option)
+	msg.Extra = append(msg.Extra, optrr)
+	msg.Question[0] = dns.Question{Name: qname, Qtype: qtype, Qclass: qclass}
+	msg.Id = dns.Id()
+
+	return msg
+}
+
+// performQuery sends the query and returns the response together with its result, which is written by writeResult
+// unless the query is repeated after backing off. It returns nil if ctx is done before the query was answered.
+func (scanner *Scanner) performQuery(ctx context.Context, request *queryRequest) *queryResponse {
+	msg := scanner.createDNSMessage(request)
+
+	c := new(dns.Client)
+	c.DialTimeout = scanner.config.TimeoutDial
+	c.ReadTimeout = scanner.config.TimeoutRead
+	c.WriteTimeout = scanner.config.TimeoutWrite
+	c.Dialer = &net.Dialer{Timeout: c.DialTimeout}
+
+	if scanner.config.LocalAddress != nil {
+		c.Dialer.LocalAddr = &net.UDPAddr{IP: scanner.config.LocalAddress}
+	}
+
+	nameserverPort := net.JoinHostPort(request.domainState.nameserverIP.String(), strconv.Itoa(request.domainState.nameserverPort))
+	exchanger := exchanger{ctx: ctx, scanner: scanner, client: c, msg: msg, server: nameserverPort}
+	response, err := exchanger.query(request.domainState.transport)
+	if err != nil && ctx.Err() != nil {
+		return nil
+	}
+
+	var answers []string
+	var cnames []string
+	var records []string
+	var ttls []uint32
+
+	var optrr *dns.OPT
+
+	ecs := &dns.EDNS0_SUBNET{
+		SourceScope: 255,
+	}
+	var nsid *dns.EDNS0_NSID
+
+	var errorType ErrorType = NO_ERR
+	var rcodeError ErrorType = NO_ERR
+	var errStr string = ""
+	if err != nil {
+		errorType = INTERNAL_ERR
+		scanner.config.Logger.debuglog("Result is not usable after %v attempts. Got error %s", exchanger.attempts, err)
+		errStr = err.Error()
+		goto exit
+	}
+
+	if response.Truncated {
+		response, err = exchanger.queryTCP()
+		if err != nil && ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			errorType = TRUNCATED_NO_TCP
+			scanner.config.Logger.debuglog("Result is not usable. Got error %s", err)
+			errStr = err.Error()
+			goto exit
+		}
+	}
+
+	// the ECS and NSID options of error responses are recorded as well, only their answers are skipped
+	switch response.Rcode {
+	case dns.RcodeRefused:
+		scanner.config.Logger.debuglog("Received response with rcode REFUSED")
+		rcodeError = REFUSED
+	case dns.RcodeServerFailure:
+		scanner.config.Logger.debuglog("Received response with rcode SERVFAIL")
+		rcodeError = SERVFAIL
+	case dns.RcodeNameError:
+		scanner.config.Logger.debuglog("Received response with rcode NXDOMAIN")
+		rcodeError = NXDOMAIN
+	}
+
+	if rcodeError == NO_ERR && !response.Authoritative && !scanner.config.Recursive {
+		scanner.config.Logger.debuglog("Received response does not point to authoritative name server")
+		errorType = NO_AUTH
+		goto exit
+	}
+
+	if len(response.Extra) == 0 {
+		scanner.config.Logger.debuglog("Received response does not contain Additional RRs")
+		errorType = NO_ADD
+	}
+
+	optrr = response.IsEdns0()
+	if optrr == nil {
+		scanner.config.Logger.debuglog("Received response has no EDNS RR")
+		errorType = NO_EDNS
+	} else {
+
+		for _, ednsoption := range optrr.Option {
+			switch ednsoption.(type) {
+			case *dns.EDNS0_SUBNET:
+				ecs = ednsoption.(*dns.EDNS0_SUBNET)
+				if ecs.Family != uint16(request.family) {
+					scanner.config.Logger.errorlog("wrong family in ECS")
+					errorType = WRONG_FAM
+					errStr = ecs.String()
+					goto exit
+				}
+
+				if (ecs.Family == 1 && ecs.SourceNetmask > 32) || (ecs.Family == 2 && ecs.SourceNetmask > 128) {
+					scanner.config.Logger.errorlog("impossible Source prefix length")
+					errorType = SCOPE_OOB
+					errStr = ecs.String()
+					goto exit
+				}
+				if !ecs.Address.Equal(request.ipAddressClient) {
+					scanner.config.Logger.errorlog("returned wrong ip address in ecs")
+					errorType = WRONG_PARAM
+					errStr = ecs.String()
+					goto exit
+				}
+			case *dns.EDNS0_NSID:
+				nsid = ednsoption.(*dns.EDNS0_NSID)
+			}
+		}
+		if ecs.SourceScope == 255 {
+			errorType = NO_ECS
+		}
+	}
+	if rcodeError != NO_ERR {
+		errorType = rcodeError
+		goto exit
+	}
+
+	for _, answer := range response.Answer {
+		scanner.config.Logger.debuglog("Received valid response, counting answers")
+		switch answer.(type) {
+		case *dns.A:
+			answers = append(answers, answer.(*dns.A).A.String())
+			ttls = append(ttls, answer.Header().Ttl)
+		case *dns.AAAA:
+			answers = append(answers, answer.(*dns.AAAA).AAAA.String())
+			ttls = append(ttls, answer.Header().Ttl)
+		case *dns.CNAME:
+			cnames = append(cnames, answer.(*dns.CNAME).Target)
+		case *dns.SVCB:
+			for _, hint := range svcbHints(answer.(*dns.SVCB)) {
+				answers = append(answers, hint)
+				ttls = append(ttls, answer.Header().Ttl)
+			}
+			records = append(records, recordData(answer))
+		case *dns.HTTPS:
+			for _, hint := range svcbHints(&answer.(*dns.HTTPS).SVCB) {
+				answers = append(answers, hint)
+				ttls = append(ttls, answer.Header().Ttl)
+			}
+			records = append(records, recordData(answer))
+		default:
+			records = append(records, recordData(answer))
+		}
+
+	}
+
+exit:
+	scanner.stats.response(errorType, ecs.SourceScope)
+	result := Result{
+		Timestamp:          time.Now(),
+		Domain:             request.domainState.domain,
+		Nameserver:         request.domainState.nameserverIP,
+		Port:               request.domainState.nameserverPort,
+		Family:             request.family,
+		ClientAddress:      request.ipAddressClient,
+		SourcePrefixLength: request.sourcePrefixLength,
+		ScopePrefixLength:  ecs.SourceScope,
+		Error:              errorType,
+		ErrStr:             errStr,
+		QueryType:          msg.Question[0].Qtype,
+		Answers:            answers,
+		CNAMEs:             cnames,
+		Records:            records,
+		TTLs:               ttls,
+		Attempts:           exchanger.attempts,
+		Transport:          exchanger.transport,
+	}
+	if response != nil {
+		result.HasResponse = true
+		result.Rcode = response.Rcode
+		result.Flags = headerFlags(&response.MsgHdr)
+		for _, rr := range response.Ns {
+			result.Authority = append(result.Authority, strings.ReplaceAll(rr.String(), "\t", " "))
+		}
+	}
+	if nsid != nil {
+		result.HasNSID = true
+		result.NSID = nsid.Nsid
+	}
+
+	var qResponse queryResponse
+	qResponse = queryResponse{
+		request:           request,
+		scopePrefixLength: ecs.SourceScope,
+		error:             errorType,
+		answers:           answers,
+		result:            &result,
+	}
+
+	return &qResponse
+}
+
+// writeResult passes the result of a query to the sink, it is called once the query is not repeated
+func (scanner *Scanner) writeResult(response *queryResponse) {
+	err := scanner.config.Sink.WriteResult(response.result)
+	if err != nil {
+		scanner.config.Logger.errorlog("failed writing result for %s", response.request.domainState.domain)
+	}
+}
+
+// svcbHints returns the addresses of the ipv4hint and ipv6hint parameters
+func svcbHints(svcb *dns.SVCB) []string {
+	var hints []string
+	for _, value := range svcb.Value {
+		switch value.(type) {
+		case *dns.SVCBIPv4Hint:
+			for _, ip := range value.(*dns.SVCBIPv4Hint).Hint {
+				hints = append(hints, ip.String())
+			}
+		case *dns.SVCBIPv6Hint:
+			for _, ip := range value.(*dns.SVCBIPv6Hint).Hint {
+				hints = append(hints, ip.String())
+			}
+		}
+	}
+	return hints
+}
+
+// headerFlags returns the names of the flags set in the header of a response
+func headerFlags(header *dns.MsgHdr) []string {
+	var flags []string
+	for _, flag := range []struct {
+		name string
+		set  bool
+	}{
+		{"aa", header.Authoritative},
+		{"tc", header.Truncated},
+		{"rd", header.RecursionDesired},
+		{"ra", header.RecursionAvailable},
+		{"ad", header.AuthenticatedData},
+		{"cd", header.CheckingDisabled},
+	} {
+		if flag.set {
+			flags = append(flags, flag.name)
+		}
+	}
+	return flags
+}
+
+// recordData returns the type and data of a resource record, e.g. "TXT \"v=spf1 -all\""
+func recordData(rr dns.RR) string {
+	return dns.Type(rr.Header().Rrtype).String() + " " + strings.TrimPrefix(rr.String(), rr.Header().String())
+}
@@ -0,0 +1,78 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package scan
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// The ECS and NSID options of a REFUSED response are recorded, its answers are not
+func TestPerformQueryRcodeErrorKeepsOptions(t *testing.T) {
+	started := make(chan struct{})
+	server := &dns.Server{
+		Addr:              "127.0.0.1:0",
+		Net:               "udp",
+		NotifyStartedFunc: func() { close(started) },
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, query *dns.Msg) {
+			response := new(dns.Msg).SetRcode(query, dns.RcodeRefused)
+			response.Answer = append(response.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: query.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+				A:   net.IPv4(192, 0, 2, 1),
+			})
+			ecs := *query.IsEdns0().Option[1].(*dns.EDNS0_SUBNET)
+			ecs.SourceScope = 16
+			response.SetEdns0(1232, false)
+			response.IsEdns0().Option = []dns.EDNS0{&dns.EDNS0_NSID{Code: dns.EDNS0NSID, Nsid: "6e73"}, &ecs}
+			w.WriteMsg(response)
+		}),
+	}
+	go server.ListenAndServe()
+	<-started
+	t.Cleanup(func() { server.Shutdown() })
+	address := server.PacketConn.LocalAddr().(*net.UDPAddr)
+
+	config := DefaultConfig()
+	config.Sink = discardSink{}
+	config.UDPSockets = 0
+	scanner, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = scanner.start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(scanner.closeTransports)
+	state := &domainState{
+		scanner:        scanner,
+		domain:         "a.example",
+		nameserverIP:   address.IP.To4(),
+		nameserverPort: address.Port,
+		transport:      TRANSPORT_UDP,
+		family:         scanner.ipv4,
+	}
+	response := scanner.performQuery(context.Background(), &queryRequest{
+		ipAddressClient:    net.IPv4(198, 51, 100, 0).To4(),
+		sourcePrefixLength: 24,
+		family:             1,
+		domainState:        state,
+	})
+	if response.error != REFUSED {
+		t.Errorf("got the error type %v", response.error)
+	}
+	if response.scopePrefixLength != 16 || response.result.ScopePrefixLength != 16 {
+		t.Errorf("got the scope prefix length %v", response.scopePrefixLength)
+	}
+	if !response.result.HasNSID || response.result.NSID != "6e73" {
+		t.Errorf("got the NSID %q", response.result.NSID)
+	}
+	if len(response.answers) != 0 || len(response.result.TTLs) != 0 {
+		t.Errorf("got the answers %v", response.answers)
+	}
+}
@@ -0,0 +1,115 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package scan
+
+import (
+	"net"
+	"net/netip"
+)
+
+// addressFamily holds the limits and prefix tables of the client subnets of one address family.
+// A scan uses the family selected with Config.IPv6, with Config.DualStack every domain is scanned with both families.
+type addressFamily struct {
+	ipv6                   bool
+	name                   string        // ipv4 or ipv6
+	scanLimits             map[int][]int //first position indicates the kind of network
+	maxSpecialPrefixScans  int
+	totalNotroutedLimit    int
+	scanResultsToFinish    uint8 //should not exceed 255
+	prefixLengthToScanWith int
+	randomizeDepth         int // the settings of the trie below are the same in both families
+	scanAllBGP             bool
+	maxNumScopeZeros       int
+	bgpPrefixes            *prefixTree
+	specialPrefixes        *prefixTree
+	queryList              []net.IPNet // the prefixes of the query list in this family, used with Config.DualStack
+	logger                 *Logger
+}
+
+func newAddressFamily(ipv6 bool, prefixLength int, limits Limits, config *Config) *addressFamily {
+	family := &addressFamily{
+		ipv6:                   ipv6,
+		name:                   "ipv4",
+		scanLimits:             make(map[int][]int),
+		maxSpecialPrefixScans:  limits.MaxSpecialPrefixScans,
+		totalNotroutedLimit:    limits.TotalNotroutedLimit,
+		scanResultsToFinish:    limits.ScanResultsToFinish,
+		prefixLengthToScanWith: prefixLength,
+		randomizeDepth:         config.RandomizeDepth,
+		scanAllBGP:             config.ScanAllBGP,
+		maxNumScopeZeros:       config.MaxScopeZeros,
+		bgpPrefixes:            newPrefixTree(),
+		specialPrefixes:        newPrefixTree(),
+		logger:                 config.Logger,
+	}
+	if ipv6 {
+		family.name = "ipv6"
+	}
+	for kind, kindLimits := range map[int]map[int]int{BGPANNOUNCED: limits.Announced, UNANNOUNCED: limits.Unannounced, TOTAL: limits.Total} {
+		family.scanLimits[kind] = make([]int, 129)
+		for prefixLength, limit := range kindLimits {
+			family.scanLimits[kind][prefixLength] = limit
+		}
+	}
+	return family
+}
+
+// scanFamilies returns the families every domain is scanned with
+func (scanner *Scanner) scanFamilies() []*addressFamily {
+	if scanner.config.DualStack {
+		return []*addressFamily{scanner.ipv4, scanner.ipv6}
+	}
+	if scanner.config.IPv6 {
+		return []*addressFamily{scanner.ipv6}
+	}
+	return []*addressFamily{scanner.ipv4}
+}
+
+// familyOf returns the family of an address
+func (scanner *Scanner) familyOf(ip net.IP) *addressFamily {
+	if ip.To4() != nil {
+		return scanner.ipv4
+	}
+	return scanner.ipv6
+}
+
+// familyOfPrefix returns the family of a prefix
+func (scanner *Scanner) familyOfPrefix(prefix netip.Prefix) *addressFamily {
+	if prefix.Addr().Is4() {
+		return scanner.ipv4
+	}
+	return scanner.ipv6
+}
+
+// familyByNumber returns the family of an ECS family number, 1 for IPv4 and 2 for IPv6
+func (scanner *Scanner) familyByNumber(number uint8) *addressFamily {
+	if number == 2 {
+		return scanner.ipv6
+	}
+	return scanner.ipv4
+}
+
+// number returns the ECS family number
+func (family *addressFamily) number() uint8 {
+	if family.ipv6 {
+		return 2
+	}
+	return 1
+}
+
+// isBGPannounced reports whether the prefix is announced itself
+func (family *addressFamily) isBGPannounced(prefix ipPrefix) bool {
+	return family.bgpPrefixes.contains(prefix)
+}
+
+// isSpecial reports whether the prefix lies inside a special prefix
+func (family *addressFamily) isSpecial(prefix ipPrefix) bool {
+	return family.specialPrefixes.covers(prefix)
+}
+
+// hasBGPsubnet reports whether the network address of an announced prefix lies inside the prefix
+func (family *addressFamily) hasBGPsubnet(prefix ipPrefix) bool {
+	return family.bgpPrefixes.startsInside(prefix)
+}
@@ -0,0 +1,113 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package scan
+
+import (
+	"errors"
+	"fmt"
+)
+
+// FileSinkConfig selects the files a FileSink writes
+type FileSinkConfig struct {
+	Dir         string
+	Format      string // OUTPUT_CSV or OUTPUT_JSONL
+	Compression string // COMPRESSION_NONE, COMPRESSION_GZIP or COMPRESSION_ZSTD
+	RotateBytes int64  // start a new part of a file once it reaches this size, 0 to disable
+	RotateRows  int64  // start a new part of a file after this number of rows, 0 to disable
+	Checkpoints bool   // checkpoints are written, compressed files are split into parts so they can be continued
+	ScopeMap    bool   // write the scope map file, strategies using the query list learn no scope map
+}
+
+// FileSink writes the results into the files ecsresults and scopemap of a directory, it is the sink of the command line tool.
+// Its files are part of the checkpoints, rows written after the checkpoint are discarded on resume.
+type FileSink struct {
+	format   string
+	results  *SynchronizedWriter
+	scopeMap *SynchronizedWriter // nil without a scope map file
+}
+
+// resultFileName returns the name of an output file for the selected output format and compression
+func (config *FileSinkConfig) resultFileName(base string) string {
+	return base + "." + config.Format + compressionExtensions[config.Compression]
+}
+
+// NewFileSink creates the result files in the directory, those of a resumed scan are continued after the position of the checkpoint
+func NewFileSink(config FileSinkConfig, resumeFrom *Checkpoint) (*FileSink, error) {
+	if config.Format != OUTPUT_CSV && config.Format != OUTPUT_JSONL {
+		return nil, fmt.Errorf("unknown output format '%v', use csv or jsonl", config.Format)
+	}
+	if _, ok := compressionExtensions[config.Compression]; !ok && config.Compression != COMPRESSION_NONE {
+		return nil, fmt.Errorf("unknown compression '%v', use gzip or zstd", config.Compression)
+	}
+	ecsResultsHeader, scopeMapHeader := "", ""
+	if config.Format == OUTPUT_CSV {
+		ecsResultsHeader = csvHeader(ecsResultColumns)
+		scopeMapHeader = csvHeader(scopeMapColumns)
+	}
+	open := func(base string, header string) (*SynchronizedWriter, error) {
+		filename := config.resultFileName(base)
+		if resumeFrom != nil {
+			return ResumeSynchronizedWriter(&config, filename, header, resumeFrom.Writers[filename])
+		}
+		return SetupSynchronizedWriter(&config, filename, header)
+	}
+
+	sink := &FileSink{format: config.Format}
+	var err error
+	sink.results, err = open("ecsresults", ecsResultsHeader)
+	if err != nil {
+		return nil, err
+	}
+	if config.ScopeMap {
+		sink.scopeMap, err = open("scopemap", scopeMapHeader)
+		if err != nil {
+			sink.results.Close()
+			return nil, err
+		}
+	}
+	return sink, nil
+}
+
+// WriteResult formats the result and writes it to the result file, for the format see ecsResultColumns
+func (sink *FileSink) WriteResult(result *Result) error {
+	return sink.results.writeRecord(appendRecord(make([]byte, 0, 256), sink.format, ecsResultColumns, result))
+}
+
+// WriteScopeMap writes the prefixes of a domain to the scope map file, for the format see scopeMapColumns
+func (sink *FileSink) WriteScopeMap(prefixes []ScopePrefix) error {
+	if sink.scopeMap == nil {
+		return nil
+	}
+	var records []byte
+	for i := range prefixes {
+		records = appendRecord(records, sink.format, scopeMapColumns, &prefixes[i])
+	}
+	return sink.scopeMap.writeRecord(records)
+}
+
+// Close flushes the files, syncs them to disk and closes them
+func (sink *FileSink) Close() error {
+	err := sink.results.Close()
+	if sink.scopeMap != nil {
+		err = errors.Join(err, sink.scopeMap.Close())
+	}
+	return err
+}
+
+// checkpointPositions flushes the files and returns the positions up to which they are on disk, keyed by file name
+func (sink *FileSink) checkpointPositions() (map[string]writerPosition, error) {
+	positions := make(map[string]writerPosition)
+	for _, w := range []*SynchronizedWriter{sink.results, sink.scopeMap} {
+		if w == nil {
+			continue
+		}
+		position, err := w.checkpointPosition()
+		if err != nil {
+			return nil, err
+		}
+		positions[w.filename] = position
+	}
+	return positions, nil
+}
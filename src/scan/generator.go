@@ -0,0 +1,58 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package scan
+
+import (
+	"slices"
+)
+
+// Built-in strategies of the ip generators, see Config.Strategy
+const (
+	STRATEGY_TRIE = "trie"
+	STRATEGY_LIST = "list"
+)
+
+// subnetGenerator chooses the client subnets one domain is queried with. A generator is created for every domain
+// when its scan starts or resumes and it is only called by one ip generator at a time.
+// State which has to survive a resume is kept in the domain state, which is written to checkpoints.
+type subnetGenerator interface {
+	// Consume takes a response to a query of the domain, it is called for every response before Next
+	Consume(response *queryResponse)
+	// Next returns the next queries of the domain as singleQuery or queryRequestList, waitingForMoreResults
+	// while responses are outstanding or domainScanFinished with the finish reason of the domain set
+	Next() *ipGeneratorResult
+}
+
+// generatorStrategy is an entry of the strategy registry
+type generatorStrategy struct {
+	newGenerator  func(domainState *domainState) subnetGenerator
+	usesQueryList bool // the strategy queries the prefixes of the query list, it needs no scan limits and learns no scope map
+}
+
+// generatorStrategies is the registry of the strategies selectable with Config.Strategy.
+// Experimental strategies register themselves with registerStrategy from an init function of their file.
+var generatorStrategies = make(map[string]*generatorStrategy)
+
+func init() {
+	registerStrategy(STRATEGY_TRIE, &generatorStrategy{newGenerator: newTrieGenerator})
+	registerStrategy(STRATEGY_LIST, &generatorStrategy{newGenerator: newListGenerator, usesQueryList: true})
+}
+
+func registerStrategy(name string, strategy *generatorStrategy) {
+	if _, exists := generatorStrategies[name]; exists {
+		panic("strategy " + name + " is registered twice")
+	}
+	generatorStrategies[name] = strategy
+}
+
+// StrategyNames returns the names of the registered strategies in alphabetical order
+func StrategyNames() []string {
+	names := make([]string, 0, len(generatorStrategies))
+	for name := range generatorStrategies {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
@@ -0,0 +1,96 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package scan
+
+// global constants indicate the kind of network (0 = not BGPANNOUNCED routable, 1 = BGPANNOUNCED routable, 2 = special use)
+
+const (
+	UNANNOUNCED = iota
+	BGPANNOUNCED
+	SPECIAL
+	TOTAL
+)
+
+// ErrorType classifies the response to a query, it is written to the error column of the results
+type ErrorType int
+
+const (
+	NO_ERR  = iota
+	NO_AUTH // non-authoritative answer
+	NO_ADD
+	NO_EDNS
+	NO_ECS
+	WRONG_FAM
+	SCOPE_OOB
+	NO_ANS // no answer RR ins reply
+	NO_REC // no fitting record in answer
+	INTERNAL_ERR
+	WRONG_PARAM
+	TRUNCATED_NO_TCP
+	REFUSED  // rcode REFUSED
+	SERVFAIL // rcode SERVFAIL
+	NXDOMAIN // rcode NXDOMAIN
+	NUM_ERROR_TYPES
+)
+
+var errorTypeNames = map[ErrorType]string{
+	NO_ERR:           "NO_ERR",
+	NO_AUTH:          "NO_AUTH",
+	NO_ADD:           "NO_ADD",
+	NO_EDNS:          "NO_EDNS",
+	NO_ECS:           "NO_ECS",
+	WRONG_FAM:        "WRONG_FAM",
+	SCOPE_OOB:        "SCOPE_OOB",
+	NO_ANS:           "NO_ANS",
+	NO_REC:           "NO_REC",
+	INTERNAL_ERR:     "INTERNAL_ERR",
+	WRONG_PARAM:      "WRONG_PARAM",
+	TRUNCATED_NO_TCP: "TRUNCATED_NO_TCP",
+	REFUSED:          "REFUSED",
+	SERVFAIL:         "SERVFAIL",
+	NXDOMAIN:         "NXDOMAIN",
+}
+
+func (error ErrorType) String() string {
+	name, ok := errorTypeNames[error]
+	if !ok {
+		return "UNKNOWN"
+	}
+	return name
+}
+
+func isPerm(error ErrorType) bool {
+	switch error {
+	case NO_ERR:
+		return false
+	case NO_AUTH:
+		return true
+	case NO_ADD:
+		return true
+	case NO_EDNS:
+		return true
+	case NO_ECS:
+		return false
+	case WRONG_FAM:
+		return true
+	case SCOPE_OOB:
+		return true
+	case NO_ANS:
+		return false
+	case NO_REC:
+		return false
+	case TRUNCATED_NO_TCP:
+		return false
+	case INTERNAL_ERR:
+		return true
+	case REFUSED:
+		return false
+	case SERVFAIL:
+		return false
+	case NXDOMAIN:
+		return true
+	}
+	return true
+}
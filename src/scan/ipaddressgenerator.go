@@ -0,0 +1,208 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package scan
+
+import (
+	"net"
+	"time"
+)
+
+/*
+ipgenerator takes a new order (order contains the important parts of the state for one Domain (e.g. last request
+and radix trie, that illustrates all former scans, needed for generating the next request parameters.
+The subnet generator of the domain, chosen with Config.Strategy, consumes the responses of the order and generates the new parameters for the next DNS request for that particular Domain. This includes a Client IP Address and a
+source prefix length. It also includes whether this was the last EDNS request for this Domain (finished flag).
+Once a domain ran out of its time budget (Config.DomainTimeout) no new parameters are generated and the scanners drop its queries
+which were not sent yet, it is finished once its outstanding queries returned.
+The parameters are cut to the query budgets of the domain and its nameserver, see applyQueryBudget.
+*/
+
+func (scanner *Scanner) ipgenerator(requests <-chan *ipGeneratorRequest, controllerQueue *ControllerQueue) {
+	for receivedRequest := range requests {
+		if receivedRequest == nil {
+			scanner.config.Logger.debuglog("IPGENERATOR: Channel was closed, exiting.")
+			break //intended for dealing with closing the channel
+		}
+		if scanner.config.Logger.debugEnabled() {
+			scanner.config.Logger.debuglog("IPGenerator: Received request for %+v.", *receivedRequest.domainState)
+		}
+
+		domainState := receivedRequest.domainState
+		if domainState.generator == nil {
+			domainState.generator = scanner.strategy.newGenerator(domainState)
+			if scanner.config.DomainTimeout > 0 && domainState.deadline.IsZero() {
+				domainState.deadline = time.Now().Add(scanner.config.DomainTimeout)
+			}
+		}
+		domainState.inFlight -= len(receivedRequest.lastScans) + receivedRequest.expired
+		for _, response := range receivedRequest.lastScans {
+			domainState.generator.Consume(response)
+		}
+		var newResult *ipGeneratorResult
+		if domainState.timedOut() {
+			newResult = domainTimedOut(domainState)
+		} else if domainState.budgetExhausted {
+			newResult = budgetExhausted(domainState)
+		} else {
+			newResult = scanner.applyQueryBudget(domainState.generator.Next())
+		}
+		domainState.inFlight += len(newResult.queries)
+		domainState.queries += len(newResult.queries)
+
+		if newResult.kind == RESULT_FINISHED {
+			scanner.writeScopeMap(newResult.domainState)
+		}
+
+		controllerQueue.condition.L.Lock()
+		scanner.config.Logger.debuglog("IPGenerator: adding new query Parameters %+v.", newResult)
+		controllerQueue.sliceIPGeneratorToController = append(controllerQueue.sliceIPGeneratorToController, newResult) //the newly generated parameters will be sent back to the Controller via the responses queue
+		controllerQueue.condition.Signal()
+		controllerQueue.condition.L.Unlock()
+	}
+}
+
+// domainTimedOut finishes a domain which ran out of its time budget once none of its queries is outstanding
+func domainTimedOut(domainState *domainState) *ipGeneratorResult {
+	if domainState.inFlight > 0 {
+		return waitingForMoreResults(domainState)
+	}
+	domainState.scanner.config.Logger.debuglog("IPGENERATOR: Domain %v ran out of its time budget, finishing scanning", domainState.domain)
+	domainState.finishReason = FINISHED_DOMAIN_TIMEOUT
+	return domainScanFinished(domainState)
+}
+
+// listGenerator queries the prefixes of the query list in order, in lists of at most 1000 queries
+type listGenerator struct {
+	domainState *domainState
+}
+
+func newListGenerator(domainState *domainState) subnetGenerator {
+	return &listGenerator{domainState: domainState}
+}
+
+func (generator *listGenerator) Consume(_ *queryResponse) {
+	generator.domainState.listResponseIndex++
+}
+
+func (generator *listGenerator) Next() *ipGeneratorResult {
+	var maxInfligth = 500
+	var maxListLength = 1000
+	domainState := generator.domainState
+	queryList := domainState.queryList()
+	if domainState.listScanIndex < len(queryList) && domainState.listResponseIndex > domainState.listScanIndex-maxInfligth {
+		return getRequestQueryList(domainState, maxListLength)
+	}
+	if domainState.listResponseIndex >= len(queryList) {
+		domainState.finishReason = FINISHED_LIST
+		return domainScanFinished(domainState)
+	}
+	return waitingForMoreResults(domainState)
+}
+
+func getRequestQueryList(domainState *domainState, maxListLength int) *ipGeneratorResult {
+	var results []*queryRequest
+	for _, listElement := range domainState.queryList()[domainState.listScanIndex:] {
+		length, _ := listElement.Mask.Size()
+		ip := listElement.IP
+		var family byte
+		// to check wether the ip is an IPv4 or IPv6
+		if ip.To4() == nil {
+			family = 2
+		} else {
+			family = 1
+		}
+		var resultElement = queryRequest{
+			ipAddressClient:    ip,
+			sourcePrefixLength: byte(length),
+			family:             family,
+			domainState:        domainState,
+		}
+		domainState.listScanIndex += 1
+		results = append(results, &resultElement)
+		// limit result size to 1000 elements
+		if len(results) >= maxListLength {
+			break
+		}
+	}
+	return queryRequestList(domainState, results)
+}
+
+// trieGenerator learns the scopes of the nameserver in a trie and queries the prefixes it has not learned yet
+type trieGenerator struct {
+	domainState *domainState
+	finished    bool
+}
+
+func newTrieGenerator(domainState *domainState) subnetGenerator {
+	if domainState.state == nil {
+		domainState.scanner.config.Logger.debuglog("IPGenerator: Received request for new domain initializing new trie")
+		domainState.state = &root{family: domainState.family, scopeZeroObserved: 0, rootIsScanned: false}
+	}
+	return &trieGenerator{domainState: domainState}
+}
+
+func (generator *trieGenerator) Consume(lastScan *queryResponse) {
+	if generator.finished || lastScan.error != 0 {
+		return
+	}
+	domainState := generator.domainState
+	lastScanClientIP := lastScan.request.ipAddressClient
+	lastScanScope := lastScan.scopePrefixLength
+	if lastScan.request.sourcePrefixLength < lastScanScope {
+		lastScanScope = lastScan.request.sourcePrefixLength
+	}
+	lastScanClientIPShortened := prefixFromNetIP(lastScanClientIP, int(lastScanScope), domainState.family.ipv6)
+	if lastScanScope > 0 {
+		domainState.recordScope(lastScanClientIPShortened, lastScan.scopePrefixLength, lastScan.answers)
+	}
+	if domainState.state.rootHandleResponse(lastScanClientIPShortened) {
+		// domain scanning finished
+		if lastScanScope == 0 {
+			domainState.finishReason = FINISHED_SCOPE_ZERO
+		} else {
+			domainState.finishReason = FINISHED_TRIE_EXHAUSTED
+		}
+		generator.finished = true
+	}
+}
+
+func (generator *trieGenerator) Next() *ipGeneratorResult {
+	domainState := generator.domainState
+	if generator.finished {
+		return domainScanFinished(domainState)
+	}
+	if domainState.permError || domainState.tempErrors > byte(domainState.scanner.config.MaxTempErrors) {
+		// if there was a permanent error or more then 3 temporary errors, we will not calculate new parameters
+		generator.domainState.scanner.config.Logger.debuglog("IPGENERATOR: Too many errors on domain %v, finishing scanning", domainState.domain)
+		if domainState.permError {
+			domainState.finishReason = FINISHED_PERM_ERROR
+		} else {
+			domainState.finishReason = FINISHED_TEMP_ERRORS
+		}
+		return domainScanFinished(domainState)
+	}
+	generator.domainState.scanner.config.Logger.debuglog("IPGENERATOR: Calculating new ECS parameters")
+	//generates the next parameters (Client IP and Client source Scope) based on previous scans
+	newIPforNewScope, newSourcePrefix, finished := calculateNextParameters(domainState.state)
+	if finished {
+		domainState.finishReason = FINISHED_TRIE_EXHAUSTED
+		return domainScanFinished(domainState)
+	}
+	return singleQuery(&queryRequest{
+		ipAddressClient:    newIPforNewScope,
+		sourcePrefixLength: newSourcePrefix,
+		family:             domainState.family.number(),
+		domainState:        domainState,
+	})
+}
+
+func calculateNextParameters(trie *root) (net.IP, byte, bool) {
+	newNet, found := getNewParameters(trie.family, trie, ipPrefix{})
+	if !found {
+		return nil, 0, true
+	} else {
+		return newNet.netIP(trie.family.ipv6), byte(newNet.length), false
+	}
+}
@@ -0,0 +1,125 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package scan
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Logger writes the log messages of a scan, see Config.Logger. A nil Logger discards all messages.
+type Logger struct {
+	debugLog *log.Logger
+	infoLog  *log.Logger
+	errorLog *log.Logger
+
+	debugDisable bool
+	infoDisable  bool
+	errorDisable bool
+}
+
+// NewLogger returns a logger writing each loglevel to the given writer.
+// Use LogDiscard to disable a certain Loglevel
+func NewLogger(debugWriter io.Writer, infoWriter io.Writer, errorWriter io.Writer) *Logger {
+	return &Logger{
+		debugLog: log.New(debugWriter, "<D>", log.Lmicroseconds),
+		infoLog:  log.New(infoWriter, "<I>", log.Lmicroseconds),
+		errorLog: log.New(errorWriter, "<E>", log.Lmicroseconds),
+
+		debugDisable: debugWriter == LogDiscard,
+		infoDisable:  infoWriter == LogDiscard,
+		errorDisable: errorWriter == LogDiscard,
+	}
+}
+
+// defaultLogger is used by scanners without Config.Logger and by the command line tool,
+// all levels are disabled until Init_Logging is called
+var defaultLogger = NewLogger(LogDiscard, LogDiscard, LogDiscard)
+
+func ReturnLoggers() (*log.Logger, *log.Logger, *log.Logger) {
+	return defaultLogger.debugLog, defaultLogger.infoLog, defaultLogger.errorLog
+}
+func ReturnDisables() (bool, bool, bool) {
+	return defaultLogger.debugDisable, defaultLogger.infoDisable, defaultLogger.errorDisable
+}
+
+var LogDiscard = ioutil.Discard
+
+// Init_Logging sets the writer of the data for each loglevel of the default logger.
+// Use LogDiscard to disable a certain Loglevel
+func Init_Logging(debugWriter io.Writer, infoWriter io.Writer, errorWriter io.Writer) {
+	defaultLogger = NewLogger(debugWriter, infoWriter, errorWriter)
+	defaultLogger.infolog("LOGGER: Level: debugDisable=%t infoDisable=%t errorDisable=%t", defaultLogger.debugDisable, defaultLogger.infoDisable, defaultLogger.errorDisable)
+}
+
+// debugEnabled reports whether debug messages are written, to skip preparing them otherwise
+func (logger *Logger) debugEnabled() bool {
+	return logger != nil && !logger.debugDisable
+}
+
+func (logger *Logger) debuglog(fmt string, v ...interface{}) {
+	if logger.debugEnabled() {
+		logger.debugLog.Printf(fmt, v...)
+	}
+}
+
+func (logger *Logger) infolog(fmt string, v ...interface{}) {
+	if logger != nil && !logger.infoDisable {
+		logger.infoLog.Printf(fmt, v...)
+	}
+}
+
+func (logger *Logger) errorlog(fmt string, v ...interface{}) {
+	if logger != nil && !logger.errorDisable {
+		logger.errorLog.Printf(fmt, v...)
+	}
+}
+
+func DebugLog(fmt string, v ...interface{}) {
+	defaultLogger.debuglog(fmt, v...)
+}
+
+func InfoLog(fmt string, v ...interface{}) {
+	defaultLogger.infolog(fmt, v...)
+}
+
+func ErrorLog(fmt string, v ...interface{}) {
+	defaultLogger.errorlog(fmt, v...)
+}
+
+func goid() int {
+	var buf [64]byte
+	n := runtime.Stack(buf[:], false)
+	idField := strings.Fields(strings.TrimPrefix(string(buf[:n]), "goroutine "))[0]
+	id, err := strconv.Atoi(idField)
+	if err != nil {
+		defaultLogger.errorlog("cannot get goroutine id: %v", err)
+	}
+	return id
+}
+
+func PrintStacktrace(all bool) {
+	defaultLogger.debuglog("Printing stack trace:")
+	n := 0
+	buf := make([]byte, 1024)
+	for {
+		n = runtime.Stack(buf, all)
+		if n < len(buf) {
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	if !defaultLogger.errorDisable {
+		defaultLogger.errorLog.Printf("\n%s", buf[:n])
+	} else {
+		fmt.Printf("\n%s", buf[:n])
+	}
+}
+
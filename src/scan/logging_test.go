@@ -0,0 +1,31 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package scan
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// Every scanner writes to the logger of its config, the default logger stays untouched
+func TestScannerLogger(t *testing.T) {
+	var logs [2]bytes.Buffer
+	for i := range logs {
+		config := DefaultConfig()
+		config.Sink = discardSink{}
+		config.Logger = NewLogger(LogDiscard, &logs[i], LogDiscard)
+		scanner, err := New(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		scanner.LogStatistics()
+	}
+	for i := range logs {
+		if lines := strings.Count(logs[i].String(), "STATS:"); lines != 1 {
+			t.Errorf("the log of scanner %v has %v statistics lines, expected 1", i, lines)
+		}
+	}
+}
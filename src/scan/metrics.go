@@ -0,0 +1,99 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package scan
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// MetricsHandler serves the statistics of the scan in the Prometheus text exposition format
+func (scanner *Scanner) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, err := w.Write(scanner.appendMetrics(nil))
+		if err != nil {
+			scanner.config.Logger.debuglog("METRICS: could not write response: %s", err)
+		}
+	})
+}
+
+// metric writes the HELP and TYPE lines of a metric family
+func metric(out []byte, name string, kind string, help string) []byte {
+	out = append(out, "# HELP "+name+" "+help+"\n"...)
+	return append(out, "# TYPE "+name+" "+kind+"\n"...)
+}
+
+// sample writes a single sample, labels are given as name and value pairs
+func sample(out []byte, name string, value float64, labels ...string) []byte {
+	out = append(out, name...)
+	if len(labels) > 0 {
+		out = append(out, '{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				out = append(out, ',')
+			}
+			out = append(out, labels[i]...)
+			out = append(out, '=')
+			out = strconv.AppendQuote(out, labels[i+1])
+		}
+		out = append(out, '}')
+	}
+	out = append(out, ' ')
+	out = strconv.AppendFloat(out, value, 'g', -1, 64)
+	return append(out, '\n')
+}
+
+// appendMetrics appends all metrics in the exposition format
+func (scanner *Scanner) appendMetrics(out []byte) []byte {
+	stats := &scanner.stats
+	out = metric(out, "ecsplorer_queries_sent_total", "counter", "Queries sent to name servers.")
+	out = sample(out, "ecsplorer_queries_sent_total", float64(stats.queriesSent.Load()))
+	out = metric(out, "ecsplorer_retries_total", "counter", "Queries repeated after an error.")
+	out = sample(out, "ecsplorer_retries_total", float64(stats.retries.Load()))
+	out = metric(out, "ecsplorer_tcp_fallbacks_total", "counter", "Queries repeated over TCP.")
+	out = sample(out, "ecsplorer_tcp_fallbacks_total", float64(stats.tcpFallbacks.Load()))
+
+	out = metric(out, "ecsplorer_backoffs_total", "counter", "Decreases of the adaptive rate of a nameserver group.")
+	out = sample(out, "ecsplorer_backoffs_total", float64(stats.backoffs.Load()))
+	out = metric(out, "ecsplorer_repeated_queries_total", "counter", "Queries repeated after backing off.")
+	out = sample(out, "ecsplorer_repeated_queries_total", float64(stats.repeatedQueries.Load()))
+
+	out = metric(out, "ecsplorer_limiter_waits_total", "counter", "Queries which had to wait for a token of the rate limiter.")
+	out = sample(out, "ecsplorer_limiter_waits_total", float64(stats.limiterWaits.Load()))
+	out = metric(out, "ecsplorer_limiter_wait_seconds_total", "counter", "Time spent waiting for tokens of the rate limiter.")
+	out = sample(out, "ecsplorer_limiter_wait_seconds_total", time.Duration(stats.limiterWaitTime.Load()).Seconds())
+
+	out = metric(out, "ecsplorer_domains_started_total", "counter", "Domains whose scan was started.")
+	out = sample(out, "ecsplorer_domains_started_total", float64(stats.domainsStarted.Load()))
+	out = metric(out, "ecsplorer_domains_finished_total", "counter", "Domains whose scan finished, by the reason it ended.")
+	for reason := range stats.finishReasons {
+		out = sample(out, "ecsplorer_domains_finished_total", float64(stats.finishReasons[reason].Load()), "reason", finish_reason(reason).String())
+	}
+	out = metric(out, "ecsplorer_domains_outstanding", "gauge", "Domains currently scanned.")
+	out = sample(out, "ecsplorer_domains_outstanding", float64(stats.outstandingDomains.Load()))
+	out = metric(out, "ecsplorer_controller_queue_length", "gauge", "Results waiting to be processed by the controller.")
+	out = sample(out, "ecsplorer_controller_queue_length", float64(stats.ipGeneratorBacklog.Load()), "queue", "ipgenerator")
+	out = sample(out, "ecsplorer_controller_queue_length", float64(stats.scannerBacklog.Load()), "queue", "scanner")
+
+	out = metric(out, "ecsplorer_responses_total", "counter", "Responses by error type.")
+	for error := range stats.responses {
+		out = sample(out, "ecsplorer_responses_total", float64(stats.responses[error].Load()), "error", ErrorType(error).String())
+	}
+
+	out = metric(out, "ecsplorer_query_rtt_seconds", "histogram", "Round trip time of answered queries.")
+	buckets, sum := stats.rtt.snapshot()
+	var cumulative int64
+	for i, bound := range rttBuckets {
+		cumulative += buckets[i]
+		out = sample(out, "ecsplorer_query_rtt_seconds_bucket", float64(cumulative), "le", strconv.FormatFloat(bound, 'g', -1, 64))
+	}
+	cumulative += buckets[len(rttBuckets)]
+	out = sample(out, "ecsplorer_query_rtt_seconds_bucket", float64(cumulative), "le", "+Inf")
+	out = sample(out, "ecsplorer_query_rtt_seconds_sum", sum.Seconds())
+	out = sample(out, "ecsplorer_query_rtt_seconds_count", float64(cumulative))
+	return out
+}
@@ -0,0 +1,136 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package scan
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// a sample line of the text exposition format: name, optional labels and value
+var sampleLine = regexp.MustCompile(`^([a-z_]+)(\{[a-z_]+="[^"]*"(,[a-z_]+="[^"]*")*\})? (\S+)$`)
+
+// scrape fetches the metrics of the handler and returns the value of every sample by name and labels,
+// it fails the test if the output is not in the text exposition format
+func scrape(t *testing.T, url string) map[string]float64 {
+	t.Helper()
+	response, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if contentType := response.Header.Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain; version=0.0.4") {
+		t.Errorf("unexpected content type %v", contentType)
+	}
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	samples := make(map[string]float64)
+	types := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSuffix(string(body), "\n"), "\n") {
+		if fields := strings.Fields(line); len(fields) >= 3 && fields[0] == "#" {
+			if fields[1] == "TYPE" {
+				types[fields[2]] = fields[3]
+			} else if fields[1] != "HELP" {
+				t.Errorf("unexpected comment %q", line)
+			}
+			continue
+		}
+		match := sampleLine.FindStringSubmatch(line)
+		if match == nil {
+			t.Errorf("line %q is not a sample", line)
+			continue
+		}
+		family := match[1]
+		if types[family] == "" {
+			family = family[:strings.LastIndex(family, "_")] // _bucket, _sum and _count of a histogram
+		}
+		if types[family] == "" {
+			t.Errorf("sample %q has no TYPE line", line)
+		}
+		value, err := strconv.ParseFloat(match[4], 64)
+		if err != nil {
+			t.Errorf("sample %q has no valid value", line)
+		}
+		samples[match[1]+match[2]] = value
+	}
+	return samples
+}
+
+func TestMetricsHandler(t *testing.T) {
+	scanner := newScriptedScanner(t, nil)
+	scanner.stats.queriesSent.Add(3)
+	scanner.stats.domainFinished(FINISHED_DOMAIN_TIMEOUT)
+	scanner.stats.response(REFUSED, 255)
+	scanner.stats.rtt.observe(2 * time.Millisecond)
+	scanner.stats.rtt.observe(10 * time.Second)
+	server := httptest.NewServer(scanner.MetricsHandler())
+	defer server.Close()
+
+	samples := scrape(t, server.URL)
+	for name, expected := range map[string]float64{
+		"ecsplorer_queries_sent_total":                             3,
+		`ecsplorer_domains_finished_total{reason="domainTimeout"}`: 1,
+		`ecsplorer_domains_finished_total{reason="trieExhausted"}`: 0,
+		`ecsplorer_responses_total{error="REFUSED"}`:               1,
+		`ecsplorer_query_rtt_seconds_bucket{le="0.001"}`:           0,
+		`ecsplorer_query_rtt_seconds_bucket{le="0.0025"}`:          1,
+		`ecsplorer_query_rtt_seconds_bucket{le="5"}`:               1,
+		`ecsplorer_query_rtt_seconds_bucket{le="+Inf"}`:            2,
+		"ecsplorer_query_rtt_seconds_count":                        2,
+		"ecsplorer_query_rtt_seconds_sum":                          10.002,
+	} {
+		value, ok := samples[name]
+		if !ok {
+			t.Errorf("%v is missing", name)
+		} else if math.Abs(value-expected) > 1e-9 {
+			t.Errorf("%v is %v, expected %v", name, value, expected)
+		}
+	}
+}
+
+// _count and _sum of the RTT histogram are taken from the same queries while queries are answered
+func TestMetricsHistogramConsistent(t *testing.T) {
+	scanner := newScriptedScanner(t, nil)
+	server := httptest.NewServer(scanner.MetricsHandler())
+	defer server.Close()
+
+	done := make(chan struct{})
+	var wait sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					scanner.stats.rtt.observe(time.Millisecond)
+				}
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		samples := scrape(t, server.URL)
+		count, sum := samples["ecsplorer_query_rtt_seconds_count"], samples["ecsplorer_query_rtt_seconds_sum"]
+		if math.Abs(count*0.001-sum) > 1e-6 {
+			t.Errorf("_count %v does not match _sum %v", count, sum)
+			break
+		}
+	}
+	close(done)
+	wait.Wait()
+}
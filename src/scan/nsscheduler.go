@@ -0,0 +1,252 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package scan
+
+import (
+	"container/heap"
+	"context"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// Groups of nameservers sharing a rate and concurrency cap
+const (
+	NS_GROUP_IP     = "ip"
+	NS_GROUP_PREFIX = "prefix" // the /24 of IPv4 and the /48 of IPv6 nameservers
+	NS_GROUP_ASN    = "asn"
+)
+
+// nameserverScheduler hands the requests of the controller to the scanners without exceeding the
+// rate and concurrency cap of a nameserver group. Every group has its own queue, requests of other
+// groups are handed out while a group waits for a token or for one of its queries to finish.
+type nameserverScheduler struct {
+	scanner  *Scanner
+	input    <-chan *ipGeneratorResult
+	output   chan *scheduledRequest
+	finished chan finishedRequest
+	queues   map[string]*nameserverQueue
+	ready    []*nameserverQueue  // groups with queued requests which may send now, served round robin
+	waiting  nameserverQueueHeap // groups with queued requests waiting for a token
+	stopping bool                // the scan was cancelled, the queued requests are handed out without tokens so the scanners return them unsent
+}
+
+// scheduledRequest is a request handed to a scanner, the scanner reports back with done once it was answered
+type scheduledRequest struct {
+	request *ipGeneratorResult
+	queue   *nameserverQueue
+	repeats int // times the current query was repeated after backing off
+}
+
+type finishedRequest struct {
+	request *scheduledRequest
+	repeat  bool // the request is queued again as the nameserver limits us
+}
+
+type nameserverQueue struct {
+	key       string
+	requests  []*scheduledRequest
+	inFlight  int
+	limiter   *tokenBucket  // nil without a rate cap
+	adaptive  *adaptiveRate // nil without adaptive rates
+	readyAt   time.Time
+	scheduled bool // the queue is in ready or waiting
+}
+
+func newNameserverScheduler(scanner *Scanner, input <-chan *ipGeneratorResult) *nameserverScheduler {
+	return &nameserverScheduler{
+		scanner:  scanner,
+		input:    input,
+		output:   make(chan *scheduledRequest),
+		finished: make(chan finishedRequest, 1024),
+		queues:   make(map[string]*nameserverQueue),
+	}
+}
+
+// nameserverGroup returns the key of the group sharing the caps with the nameserver
+func (scheduler *nameserverScheduler) nameserverGroup(nameserverIP net.IP) string {
+	switch scheduler.scanner.config.NSGroup {
+	case NS_GROUP_PREFIX:
+		if ip4 := nameserverIP.To4(); ip4 != nil {
+			return ip4.Mask(net.CIDRMask(24, 32)).String() + "/24"
+		}
+		return nameserverIP.Mask(net.CIDRMask(48, 128)).String() + "/48"
+	case NS_GROUP_ASN:
+		address, ok := netip.AddrFromSlice(nameserverIP)
+		if ok {
+			address = address.Unmap()
+			for _, length := range scheduler.scanner.pfx2asLengths {
+				prefix, err := address.Prefix(length)
+				if err != nil {
+					continue
+				}
+				if asn, ok := scheduler.scanner.config.Pfx2AS[prefix]; ok {
+					return "AS" + asn
+				}
+			}
+		}
+		// nameservers in unannounced address space are limited on their own
+		return nameserverIP.String()
+	}
+	return nameserverIP.String()
+}
+
+// run distributes the requests until the input channel is closed, then it closes the output channel
+func (scheduler *nameserverScheduler) run(ctx context.Context) {
+	timer := time.NewTimer(time.Hour)
+	var next *scheduledRequest // request which took its token but was not taken by a scanner yet
+	input := scheduler.input
+	done := ctx.Done()
+	for input != nil || next != nil || len(scheduler.ready) > 0 || len(scheduler.waiting) > 0 {
+		if next == nil {
+			next = scheduler.pick()
+		}
+		var output chan *scheduledRequest
+		if next != nil {
+			output = scheduler.output
+		}
+		if len(scheduler.waiting) > 0 {
+			timer.Reset(time.Until(scheduler.waiting[0].readyAt))
+		}
+
+		select {
+		case request, ok := <-input:
+			if !ok || request == nil {
+				input = nil
+				break
+			}
+			scheduler.enqueue(request)
+		case output <- next:
+			next = nil
+		case finished := <-scheduler.finished:
+			queue := finished.request.queue
+			queue.inFlight--
+			if finished.repeat {
+				queue.requests = append([]*scheduledRequest{finished.request}, queue.requests...)
+			}
+			scheduler.schedule(queue)
+		case <-timer.C:
+			now := time.Now()
+			for len(scheduler.waiting) > 0 && !scheduler.waiting[0].readyAt.After(now) {
+				queue := heap.Pop(&scheduler.waiting).(*nameserverQueue)
+				scheduler.ready = append(scheduler.ready, queue)
+			}
+		case <-done:
+			done = nil
+			scheduler.stopping = true
+			for len(scheduler.waiting) > 0 {
+				queue := heap.Pop(&scheduler.waiting).(*nameserverQueue)
+				scheduler.ready = append(scheduler.ready, queue)
+			}
+		}
+		timer.Stop()
+	}
+	close(scheduler.output)
+}
+
+func (scheduler *nameserverScheduler) enqueue(request *ipGeneratorResult) {
+	config := &scheduler.scanner.config
+	key := scheduler.nameserverGroup(request.domainState.nameserverIP)
+	queue, ok := scheduler.queues[key]
+	if !ok {
+		queue = &nameserverQueue{key: key}
+		if config.Adaptive {
+			ceiling := scheduler.scanner.adaptiveCeiling()
+			queue.limiter = newTokenBucket(ceiling, config.NSQueryBurst)
+			queue.adaptive = newAdaptiveRate(queue.limiter, ceiling, config.AdaptiveFloor, config.AdaptiveErrors, &scheduler.scanner.stats, config.Logger)
+		} else if config.NSQueryRate > 0 {
+			queue.limiter = newTokenBucket(config.NSQueryRate, config.NSQueryBurst)
+		}
+		scheduler.queues[key] = queue
+	}
+	queue.requests = append(queue.requests, &scheduledRequest{request: request, queue: queue})
+	scheduler.schedule(queue)
+}
+
+// schedule puts a queue with requests and free capacity into the ready list
+func (scheduler *nameserverScheduler) schedule(queue *nameserverQueue) {
+	nsConcurrency := scheduler.scanner.config.NSConcurrency
+	if queue.scheduled || len(queue.requests) == 0 || (nsConcurrency > 0 && queue.inFlight >= nsConcurrency) {
+		// idle queues are forgotten unless they have to remember the tokens of their group
+		if !queue.scheduled && len(queue.requests) == 0 && queue.inFlight == 0 && queue.limiter == nil {
+			delete(scheduler.queues, queue.key)
+		}
+		return
+	}
+	queue.scheduled = true
+	scheduler.ready = append(scheduler.ready, queue)
+}
+
+// pick returns the next request which may be sent now or nil if every group has to wait
+func (scheduler *nameserverScheduler) pick() *scheduledRequest {
+	for len(scheduler.ready) > 0 {
+		queue := scheduler.ready[0]
+		scheduler.ready = scheduler.ready[1:]
+		request := queue.requests[0]
+		// request lists take the tokens of their queries in the scanner
+		if request.request.kind != RESULT_LIST && queue.limiter != nil && !scheduler.stopping {
+			if ok, wait := queue.limiter.take(); !ok {
+				queue.readyAt = time.Now().Add(wait)
+				heap.Push(&scheduler.waiting, queue)
+				continue
+			}
+		}
+		queue.requests = queue.requests[1:]
+		queue.inFlight++
+		queue.scheduled = false
+		scheduler.schedule(queue)
+		return request
+	}
+	return nil
+}
+
+// done releases the concurrency slot of the request, it is called by the scanner
+func (scheduler *nameserverScheduler) done(request *scheduledRequest) {
+	scheduler.finished <- finishedRequest{request: request}
+}
+
+// repeat releases the concurrency slot of the request and queues it again
+func (scheduler *nameserverScheduler) repeat(request *scheduledRequest) {
+	scheduler.finished <- finishedRequest{request: request, repeat: true}
+}
+
+// repeatAfterBackoff adapts the rate of the nameserver group to the response and reports whether the query has to be repeated.
+// A query is repeated at most as often as the rate can be decreased from the ceiling to the floor, afterwards its error counts
+// for the domain, e.g. REFUSED of a lame delegation sharing the nameserver with healthy domains.
+func (request *scheduledRequest) repeatAfterBackoff(error ErrorType) bool {
+	if request.queue == nil || request.queue.adaptive == nil {
+		return false
+	}
+	adaptive := request.queue.adaptive
+	if !adaptive.update(error) || request.repeats >= adaptive.maxRepeats {
+		return false
+	}
+	request.repeats++
+	adaptive.stats.repeatedQueries.Add(1)
+	return true
+}
+
+// waitForNameserverToken waits for the rate cap of the nameserver group of a query inside a request list
+func (request *scheduledRequest) waitForNameserverToken(ctx context.Context) error {
+	if request.queue != nil && request.queue.limiter != nil {
+		_, err := request.queue.limiter.wait(ctx)
+		return err
+	}
+	return nil
+}
+
+// nameserverQueueHeap orders the groups waiting for a token by the time the token is available
+type nameserverQueueHeap []*nameserverQueue
+
+func (h nameserverQueueHeap) Len() int           { return len(h) }
+func (h nameserverQueueHeap) Less(i, j int) bool { return h[i].readyAt.Before(h[j].readyAt) }
+func (h nameserverQueueHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *nameserverQueueHeap) Push(x any)        { *h = append(*h, x.(*nameserverQueue)) }
+func (h *nameserverQueueHeap) Pop() any {
+	old := *h
+	queue := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return queue
+}
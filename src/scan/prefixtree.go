@@ -0,0 +1,94 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package scan
+
+import (
+	"net/netip"
+)
+
+// prefixTree stores the prefixes of one family in a binary tree over their bits.
+// A node exists only if a prefix ends in it or below it.
+type prefixTree struct {
+	root prefixTreeNode
+	size int
+}
+
+type prefixTreeNode struct {
+	children [2]*prefixTreeNode
+	terminal bool // a prefix ends in this node
+}
+
+func newPrefixTree() *prefixTree {
+	return &prefixTree{}
+}
+
+func (tree *prefixTree) insert(prefix netip.Prefix) {
+	prefix = prefix.Masked()
+	address := uint128FromAddr(prefix.Addr())
+	node := &tree.root
+	for i := 0; i < prefix.Bits(); i++ {
+		bit := address.bit(i)
+		if node.children[bit] == nil {
+			node.children[bit] = &prefixTreeNode{}
+		}
+		node = node.children[bit]
+	}
+	if !node.terminal {
+		node.terminal = true
+		tree.size++
+	}
+}
+
+func (tree *prefixTree) len() int {
+	return tree.size
+}
+
+// node returns the node of the prefix or nil if no prefix of the tree ends in or below it
+func (tree *prefixTree) node(prefix ipPrefix) *prefixTreeNode {
+	node := &tree.root
+	for i := 0; i < prefix.length && node != nil; i++ {
+		node = node.children[prefix.bit(i)]
+	}
+	return node
+}
+
+// contains reports whether the prefix is in the tree
+func (tree *prefixTree) contains(prefix ipPrefix) bool {
+	node := tree.node(prefix)
+	return node != nil && node.terminal
+}
+
+// covers reports whether a prefix of the tree contains the prefix
+func (tree *prefixTree) covers(prefix ipPrefix) bool {
+	node := &tree.root
+	for i := 0; ; i++ {
+		if node.terminal {
+			return true
+		}
+		if i == prefix.length {
+			return false
+		}
+		node = node.children[prefix.bit(i)]
+		if node == nil {
+			return false
+		}
+	}
+}
+
+// startsInside reports whether the network address of a prefix of the tree lies inside the prefix.
+// These are the prefixes of the tree inside the prefix and the shorter ones which start with it.
+func (tree *prefixTree) startsInside(prefix ipPrefix) bool {
+	node := &tree.root
+	for i := 0; i < prefix.length; i++ {
+		if node.terminal && prefix.truncate(i).address == prefix.address {
+			return true
+		}
+		node = node.children[prefix.bit(i)]
+		if node == nil {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,105 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package scan
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// tokenBucket limits the rate of queries.
+// Tokens are added continuously with the rate up to the burst size, each query takes one.
+// A query which finds no token reserves the next one and sleeps until it is available, so waiting queries are served in order.
+type tokenBucket struct {
+	mutex     sync.Mutex
+	rate      float64 // tokens per second, <= 0 for unlimited
+	burst     float64
+	tokens    float64 // negative if tokens are reserved by waiting queries
+	last      time.Time
+	autoBurst bool // the burst follows the rate
+}
+
+// newTokenBucket creates a full bucket, a burst of 0 allows the queries of one second (at least one)
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	bucket := &tokenBucket{
+		rate:  rate,
+		burst: float64(burst),
+		last:  time.Now(),
+	}
+	if burst <= 0 {
+		bucket.autoBurst = true
+		bucket.burst = math.Max(1, math.Ceil(rate))
+	}
+	bucket.tokens = bucket.burst
+	return bucket
+}
+
+// wait takes a token and blocks until it is available or ctx is done, it returns the time spent waiting
+func (bucket *tokenBucket) wait(ctx context.Context) (time.Duration, error) {
+	if bucket.rate <= 0 {
+		return 0, nil
+	}
+	bucket.mutex.Lock()
+	bucket.refill()
+	bucket.tokens--
+	var wait time.Duration
+	if bucket.tokens < 0 {
+		wait = time.Duration(-bucket.tokens / bucket.rate * float64(time.Second))
+	}
+	bucket.mutex.Unlock()
+
+	return wait, sleepContext(ctx, wait)
+}
+
+// sleepContext sleeps for the duration unless ctx is done before, then it returns the error of ctx
+func sleepContext(ctx context.Context, duration time.Duration) error {
+	if duration <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// take takes a token if one is available, otherwise it returns the time until the next token is available
+func (bucket *tokenBucket) take() (bool, time.Duration) {
+	if bucket.rate <= 0 {
+		return true, 0
+	}
+	bucket.mutex.Lock()
+	defer bucket.mutex.Unlock()
+	bucket.refill()
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - bucket.tokens) / bucket.rate * float64(time.Second))
+}
+
+// setRate changes the rate, the tokens collected so far are kept
+func (bucket *tokenBucket) setRate(rate float64) {
+	bucket.mutex.Lock()
+	defer bucket.mutex.Unlock()
+	bucket.refill()
+	bucket.rate = rate
+	if bucket.autoBurst {
+		bucket.burst = math.Max(1, math.Ceil(rate))
+		bucket.tokens = math.Min(bucket.burst, bucket.tokens)
+	}
+}
+
+// refill adds the tokens since the last call, the lock must be held
+func (bucket *tokenBucket) refill() {
+	now := time.Now()
+	bucket.tokens = math.Min(bucket.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*bucket.rate)
+	bucket.last = now
+}
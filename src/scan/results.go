@@ -0,0 +1,270 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package scan
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Output formats
+const (
+	OUTPUT_CSV   = "csv"
+	OUTPUT_JSONL = "jsonl"
+)
+
+// Result is a single query and its response as passed to the ResultSink
+type Result struct {
+	Timestamp          time.Time
+	Domain             string
+	Nameserver         net.IP
+	Port               int
+	Family             byte
+	ClientAddress      net.IP
+	SourcePrefixLength byte
+	ScopePrefixLength  byte // 255 if the response had no ECS option
+	Error              ErrorType
+	ErrStr             string
+	HasNSID            bool
+	NSID               string // hex encoded as received
+	QueryType          uint16
+	Answers            []string // addresses, including the hints of SVCB and HTTPS records
+	CNAMEs             []string
+	Records            []string // answers of other types and SVCB/HTTPS records as "TYPE data"
+	TTLs               []uint32 // TTL of every address in Answers in the same order, CNAMEs and Records have none
+
+	HasResponse bool // false if no response was received, e.g. after a timeout
+	Rcode       int
+	Flags       []string // header flags, e.g. aa
+	Authority   []string // records of the authority section in presentation format
+
+	Attempts  int    // queries sent including retries
+	Transport string // transport of the last attempt
+}
+
+// resultColumn describes one field of an output record.
+// A column is written to CSV if csv is set and to JSON Lines if json is set, both use name as column header or key.
+type resultColumn[T any] struct {
+	name string
+	csv  func(line []byte, record *T) []byte
+	json func(line []byte, record *T) []byte
+}
+
+// ecsResultColumns is the format of the result file
+var ecsResultColumns = []resultColumn[Result]{
+	stringColumn("domain", func(r *Result) string { return r.Domain }),
+	stringColumn("ns", func(r *Result) string { return r.Nameserver.String() }),
+	uintColumn("family", func(r *Result) uint64 { return uint64(r.Family) }),
+	stringColumn("clientAddress", func(r *Result) string { return r.ClientAddress.String() }),
+	uintColumn("sourcePrefixLength", func(r *Result) uint64 { return uint64(r.SourcePrefixLength) }),
+	uintColumn("scopePrefixLength", func(r *Result) uint64 { return uint64(r.ScopePrefixLength) }),
+	uintColumn("error", func(r *Result) uint64 { return uint64(r.Error) }),
+	{
+		name: "errorName",
+		json: func(line []byte, r *Result) []byte { return appendJSONString(line, r.Error.String()) },
+	},
+	{
+		name: "errStr",
+		csv: func(line []byte, r *Result) []byte {
+			if r.ErrStr == "" {
+				return line
+			}
+			return appendCSVQuoted(line, r.ErrStr)
+		},
+		json: func(line []byte, r *Result) []byte { return appendJSONString(line, r.ErrStr) },
+	},
+	{
+		name: "nsid",
+		csv: func(line []byte, r *Result) []byte {
+			if !r.HasNSID {
+				return append(line, "[]"...)
+			}
+			return append(line, r.NSID...)
+		},
+		json: func(line []byte, r *Result) []byte {
+			if !r.HasNSID {
+				return append(line, "null"...)
+			}
+			return appendJSONString(line, decodeNSID(r.NSID))
+		},
+	},
+	listColumn("answers", func(r *Result) []string { return r.Answers }),
+	listColumn("cnames", func(r *Result) []string { return r.CNAMEs }),
+	intColumn("timestamp", func(r *Result) int64 { return r.Timestamp.Unix() }),
+	stringColumn("qtype", func(r *Result) string { return dns.Type(r.QueryType).String() }),
+	listColumn("records", func(r *Result) []string { return r.Records }),
+	stringColumn("rcode", func(r *Result) string {
+		if !r.HasResponse {
+			return ""
+		}
+		return dns.RcodeToString[r.Rcode]
+	}),
+	listColumn("flags", func(r *Result) []string { return r.Flags }),
+	{
+		name: "ttls",
+		csv: func(line []byte, r *Result) []byte {
+			if len(r.TTLs) == 0 {
+				return line
+			}
+			return append(appendUintList(append(line, '"'), r.TTLs), '"')
+		},
+		json: func(line []byte, r *Result) []byte { return appendUintList(line, r.TTLs) },
+	},
+	listColumn("authority", func(r *Result) []string { return r.Authority }),
+	intColumn("port", func(r *Result) int64 { return int64(r.Port) }),
+	intColumn("attempts", func(r *Result) int64 { return int64(r.Attempts) }),
+	stringColumn("transport", func(r *Result) string { return r.Transport }),
+}
+
+// decodeNSID returns the NSID as text, NSIDs which are no valid text stay hex encoded
+func decodeNSID(nsid string) string {
+	decoded, err := hex.DecodeString(nsid)
+	if err != nil {
+		return nsid
+	}
+	for _, c := range decoded {
+		if c < 0x20 || c > 0x7e {
+			return nsid
+		}
+	}
+	return string(decoded)
+}
+
+func stringColumn[T any](name string, value func(*T) string) resultColumn[T] {
+	return resultColumn[T]{
+		name: name,
+		csv:  func(line []byte, record *T) []byte { return append(line, value(record)...) },
+		json: func(line []byte, record *T) []byte { return appendJSONString(line, value(record)) },
+	}
+}
+
+func uintColumn[T any](name string, value func(*T) uint64) resultColumn[T] {
+	appendValue := func(line []byte, record *T) []byte { return strconv.AppendUint(line, value(record), 10) }
+	return resultColumn[T]{name: name, csv: appendValue, json: appendValue}
+}
+
+func intColumn[T any](name string, value func(*T) int64) resultColumn[T] {
+	appendValue := func(line []byte, record *T) []byte { return strconv.AppendInt(line, value(record), 10) }
+	return resultColumn[T]{name: name, csv: appendValue, json: appendValue}
+}
+
+func listColumn[T any](name string, value func(*T) []string) resultColumn[T] {
+	return resultColumn[T]{
+		name: name,
+		csv:  func(line []byte, record *T) []byte { return appendCSVList(line, value(record)) },
+		json: func(line []byte, record *T) []byte { return appendJSONList(line, value(record)) },
+	}
+}
+
+// csvHeader returns the header line of the CSV output
+func csvHeader[T any](columns []resultColumn[T]) string {
+	var names []string
+	for _, column := range columns {
+		if column.csv != nil {
+			names = append(names, column.name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// appendRecord appends the record in the given output format including the trailing newline
+func appendRecord[T any](line []byte, format string, columns []resultColumn[T], record *T) []byte {
+	if format == OUTPUT_JSONL {
+		line = append(line, '{')
+		first := true
+		for _, column := range columns {
+			if column.json == nil {
+				continue
+			}
+			if !first {
+				line = append(line, ',')
+			}
+			first = false
+			line = appendJSONString(line, column.name)
+			line = append(line, ':')
+			line = column.json(line, record)
+		}
+		line = append(line, '}')
+	} else {
+		first := true
+		for _, column := range columns {
+			if column.csv == nil {
+				continue
+			}
+			if !first {
+				line = append(line, ',')
+			}
+			first = false
+			line = column.csv(line, record)
+		}
+	}
+	return append(line, '\n')
+}
+
+// appendCSVQuoted quotes a field and escapes quotes inside
+func appendCSVQuoted(line []byte, field string) []byte {
+	line = append(line, '"')
+	line = append(line, strings.ReplaceAll(field, "\"", "\"\"")...)
+	return append(line, '"')
+}
+
+// csvListEscaper escapes an element of a python list and the quotes of the CSV field around it
+var csvListEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`, `"`, `""`)
+
+// appendCSVList writes a list as quoted python list, e.g. "['a','b']", backslashes and single quotes in elements are escaped
+func appendCSVList(line []byte, list []string) []byte {
+	if len(list) == 0 {
+		return line
+	}
+	line = append(line, '"')
+	line = append(line, '[')
+	for i, element := range list {
+		line = append(line, '\'')
+		line = append(line, csvListEscaper.Replace(element)...)
+		line = append(line, '\'')
+		if i < len(list)-1 {
+			line = append(line, ',')
+		}
+	}
+	line = append(line, ']')
+	return append(line, '"')
+}
+
+// appendUintList writes a list of numbers, e.g. [60,300], the same way for CSV and JSON
+func appendUintList(line []byte, list []uint32) []byte {
+	line = append(line, '[')
+	for i, element := range list {
+		if i > 0 {
+			line = append(line, ',')
+		}
+		line = strconv.AppendUint(line, uint64(element), 10)
+	}
+	return append(line, ']')
+}
+
+func appendJSONString(line []byte, value string) []byte {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return append(line, "null"...)
+	}
+	return append(line, encoded...)
+}
+
+func appendJSONList(line []byte, list []string) []byte {
+	line = append(line, '[')
+	for i, element := range list {
+		if i > 0 {
+			line = append(line, ',')
+		}
+		line = appendJSONString(line, element)
+	}
+	return append(line, ']')
+}
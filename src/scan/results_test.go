@@ -0,0 +1,23 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package scan
+
+import (
+	"encoding/csv"
+	"strings"
+	"testing"
+)
+
+// Quotes and backslashes in list elements are escaped, so the field is one CSV field holding a python list
+func TestAppendCSVListEscapes(t *testing.T) {
+	line := appendCSVList([]byte("a,"), []string{`TXT "it's"`, `c:\`, "plain"})
+	record, err := csv.NewReader(strings.NewReader(string(line))).Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(record) != 2 || record[1] != `['TXT "it\'s"','c:\\','plain']` {
+		t.Errorf("got the fields %q", record)
+	}
+}
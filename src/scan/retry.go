@@ -0,0 +1,142 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package scan
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// retryBackoff returns the time to wait before a retry, starting with retry 1.
+// It doubles with every retry up to the cap, half of it is random (equal jitter) so retries to the same nameserver spread out.
+func (scanner *Scanner) retryBackoff(retry int) time.Duration {
+	base, cap := scanner.config.RetryBackoff, scanner.config.RetryBackoffCap
+	if base <= 0 || retry < 1 {
+		return 0
+	}
+	backoff := cap
+	if retry < 32 && base<<(retry-1) < cap {
+		backoff = base << (retry - 1)
+	}
+	return backoff/2 + rand.N(backoff/2+1)
+}
+
+// exchanger sends the query of performQuery following the retry policy.
+// It counts the attempts and remembers the transport of the last attempt for the result file.
+type exchanger struct {
+	ctx       context.Context // the queries are abandoned once it is done
+	scanner   *Scanner
+	client    *dns.Client
+	msg       *dns.Msg
+	server    string
+	attempts  int
+	transport string
+}
+
+func (e *exchanger) exchange(transport string) (*dns.Msg, error) {
+	e.attempts++
+	e.transport = transport
+	var response *dns.Msg
+	var rtt time.Duration
+	var err error
+	switch transport {
+	case TRANSPORT_TCP:
+		response, rtt, err = e.scanner.tcpPool.exchange(e.ctx, e.msg, e.server)
+	case TRANSPORT_TLS:
+		response, rtt, err = e.scanner.tlsPool.exchange(e.ctx, e.msg, e.server)
+	case TRANSPORT_HTTPS:
+		response, rtt, err = e.scanner.dohTransport.exchange(e.ctx, e.msg, e.server)
+	default:
+		e.client.Net = transport
+		response, rtt, err = e.exchangeConn()
+	}
+	if err == nil {
+		e.scanner.stats.rtt.observe(rtt)
+	}
+	return response, err
+}
+
+// exchangeConn sends the query over a new connection of the client, the client only takes the deadline of ctx
+// into account so the connection is interrupted once ctx is done
+func (e *exchanger) exchangeConn() (*dns.Msg, time.Duration, error) {
+	conn, err := e.client.DialContext(e.ctx, e.server)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(e.ctx, func() {
+		conn.SetDeadline(time.Now())
+	})
+	defer stop()
+	return e.client.ExchangeWithConnContext(e.ctx, e.msg, conn)
+}
+
+// retry sends the query over the transport and retries it up to the given number of times on errors
+func (e *exchanger) retry(transport string, retries int) (*dns.Msg, error) {
+	response, err := e.exchange(transport)
+	for retry := 1; err != nil && retry <= retries; retry++ {
+		if sleepContext(e.ctx, e.scanner.retryBackoff(retry)) != nil {
+			return nil, e.ctx.Err()
+		}
+		e.scanner.stats.retries.Add(1)
+		response, err = e.exchange(transport)
+	}
+	return response, err
+}
+
+// query sends the query over the transport and retries it up to Config.Retries times on errors.
+// With Config.TCPFallback the last retry of UDP queries is sent over TCP.
+func (e *exchanger) query(transport string) (*dns.Msg, error) {
+	retries := e.scanner.config.Retries
+	if transport != TRANSPORT_UDP {
+		return e.retry(transport, retries)
+	}
+
+	udpRetries := retries
+	if e.scanner.config.TCPFallback && retries > 0 {
+		udpRetries--
+	}
+
+	var response *dns.Msg
+	var err error
+	if udpEngine := e.scanner.udpEngine; udpEngine != nil {
+		// the engine retransmits the query itself
+		var rtt time.Duration
+		var sends int
+		response, rtt, sends, err = udpEngine.exchange(e.ctx, e.msg, e.server, 1+udpRetries)
+		e.attempts += sends
+		e.transport = TRANSPORT_UDP
+		if err == nil {
+			e.scanner.stats.rtt.observe(rtt)
+		}
+	} else {
+		response, err = e.retry(TRANSPORT_UDP, udpRetries)
+	}
+
+	if err != nil && udpRetries < retries {
+		e.scanner.config.Logger.debuglog("Falling back to TCP after %v attempts over UDP. Got error %s", e.attempts, err)
+		if sleepContext(e.ctx, e.scanner.retryBackoff(retries)) != nil {
+			return nil, e.ctx.Err()
+		}
+		e.scanner.stats.retries.Add(1)
+		e.scanner.stats.tcpFallbacks.Add(1)
+		response, err = e.exchange(TRANSPORT_TCP)
+	}
+	return response, err
+}
+
+// queryTCP repeats a truncated query over TCP and retries it up to Config.TruncationRetries times.
+// Truncated responses over the other transports are repeated over the same transport.
+func (e *exchanger) queryTCP() (*dns.Msg, error) {
+	transport := e.transport
+	if transport == TRANSPORT_UDP {
+		e.scanner.stats.tcpFallbacks.Add(1)
+		transport = TRANSPORT_TCP
+	}
+	return e.retry(transport, e.scanner.config.TruncationRetries)
+}
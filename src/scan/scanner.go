@@ -0,0 +1,302 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Package scan learns which client subnets authoritative nameservers distinguish with EDNS Client Subnet (ECS).
+// A Scanner sends queries with varying client subnets for every domain-nameserver pair it is given and passes
+// the responses and the learned scope map to a ResultSink.
+package scan
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"slices"
+	"sync"
+)
+
+// Scanner runs one scan. It has its own rate limits, statistics and connections, so several scans with different
+// configurations can run in one process.
+type Scanner struct {
+	config        Config
+	strategy      *generatorStrategy
+	ipv4          *addressFamily
+	ipv6          *addressFamily
+	queryList     []net.IPNet
+	pfx2asLengths []int // prefix lengths in Config.Pfx2AS, longest first
+	stats         scanStatistics
+	limiter       *tokenBucket
+	nsScheduler   *nameserverScheduler
+	udpEngine     *udpQueryEngine
+	tcpPool       *streamPool
+	tlsPool       *streamPool
+	dohTransport  *dohClient
+	nsBudget      *nameserverBudget // nil without Config.MaxQueriesPerNameserver
+	stop          chan struct{}     // closed by Stop
+	stopOnce      sync.Once
+}
+
+// ErrStopped is returned by Run if the scan was stopped with Stop before all domains were finished
+var ErrStopped = errors.New("scan was stopped")
+
+// Domain is a domain-nameserver pair to scan, a line of the input file of the command line tool
+type Domain struct {
+	Name       string
+	Nameserver net.IP
+	Port       int    // 0 for the port of the config or the default port of the transport
+	QueryType  uint16 // 0 for the query type of the config
+	Transport  string // empty for the transport of the config
+}
+
+// Domains is the input of a scan
+type Domains interface {
+	// Next returns the next domain to scan, false once there are no more
+	Next() (Domain, bool)
+}
+
+// DomainFunc is a function returning the domains to scan one after another
+type DomainFunc func() (Domain, bool)
+
+func (next DomainFunc) Next() (Domain, bool) {
+	return next()
+}
+
+// DomainList returns the domains of a slice
+func DomainList(domains []Domain) Domains {
+	return DomainFunc(func() (Domain, bool) {
+		if len(domains) == 0 {
+			return Domain{}, false
+		}
+		domain := domains[0]
+		domains = domains[1:]
+		return domain, true
+	})
+}
+
+// ResultSink receives the results of a scan. It is called concurrently by the scanners and ip generators.
+type ResultSink interface {
+	// WriteResult is called for every query with its response
+	WriteResult(result *Result) error
+	// WriteScopeMap is called with the prefixes covering the scope map of a domain once its scan finished
+	WriteScopeMap(prefixes []ScopePrefix) error
+}
+
+// New prepares a scan, the connections are opened by Run
+func New(config Config) (*Scanner, error) {
+	err := config.Validate()
+	if err != nil {
+		return nil, err
+	}
+	if config.Sink == nil {
+		return nil, errors.New("no result sink")
+	}
+	scanner := &Scanner{
+		config:   config,
+		strategy: generatorStrategies[config.strategyName()],
+		stop:     make(chan struct{}),
+	}
+	if scanner.config.Logger == nil {
+		scanner.config.Logger = defaultLogger
+	}
+	if scanner.config.Workers <= 0 {
+		// one worker per query of a second is enough for nameservers answering within a second
+		if config.QueryRate > 0 {
+			scanner.config.Workers = int(math.Min(math.Ceil(config.QueryRate), 10000))
+		} else {
+			scanner.config.Workers = 1000
+		}
+	}
+	if scanner.config.StreamPipeline <= 0 {
+		scanner.config.StreamPipeline = 1
+	}
+
+	scanner.ipv4 = newAddressFamily(false, config.PrefixLengthIPv4, config.LimitsIPv4, &scanner.config)
+	scanner.ipv6 = newAddressFamily(true, config.PrefixLengthIPv6, config.LimitsIPv6, &scanner.config)
+	// the prefixes of both families can be in the same list
+	for _, prefix := range config.BGPPrefixes {
+		scanner.familyOfPrefix(prefix).bgpPrefixes.insert(prefix)
+	}
+	scanner.config.Logger.debuglog("SCANNER:    BGPANNOUNCED Prefixes were stored, %v for IPv4 and %v for IPv6.", scanner.ipv4.bgpPrefixes.len(), scanner.ipv6.bgpPrefixes.len())
+	for _, prefix := range config.SpecialPrefixes {
+		scanner.familyOfPrefix(prefix).specialPrefixes.insert(prefix)
+	}
+	scanner.config.Logger.debuglog("SCANNER:    Special Prefixes were stored, %v for IPv4 and %v for IPv6.", scanner.ipv4.specialPrefixes.len(), scanner.ipv6.specialPrefixes.len())
+	for _, prefix := range config.QueryList {
+		prefix = prefix.Masked()
+		queryNet := net.IPNet{IP: prefix.Addr().AsSlice(), Mask: net.CIDRMask(prefix.Bits(), prefix.Addr().BitLen())}
+		scanner.queryList = append(scanner.queryList, queryNet)
+		family := scanner.familyOf(queryNet.IP)
+		family.queryList = append(family.queryList, queryNet)
+	}
+	for prefix := range config.Pfx2AS {
+		if !slices.Contains(scanner.pfx2asLengths, prefix.Bits()) {
+			scanner.pfx2asLengths = append(scanner.pfx2asLengths, prefix.Bits())
+		}
+	}
+	slices.Sort(scanner.pfx2asLengths)
+	slices.Reverse(scanner.pfx2asLengths)
+
+	scanner.limiter = newTokenBucket(config.QueryRate, config.QueryBurst)
+	if config.MaxQueriesPerNameserver > 0 {
+		scanner.nsBudget = newNameserverBudget(config.MaxQueriesPerNameserver)
+	}
+	return scanner, nil
+}
+
+/*
+Run scans the domains and returns once all of them are finished. If ctx is done or the scan ran for Config.MaxDuration,
+no new domains are started, the queries in flight are abandoned, a last checkpoint is written and the error of ctx is returned.
+The abandoned queries are part of the checkpoint and sent again on resume. Stop ends the scan without abandoning queries.
+A resumed scan skips the domains taken from the input before the checkpoint, so it has to be given the same domains.
+Run must only be called once.
+*/
+func (scanner *Scanner) Run(ctx context.Context, domains Domains) error {
+	err := scanner.start()
+	if err != nil {
+		return err
+	}
+	defer scanner.closeTransports()
+
+	var taken int64 = 0 // domains taken from the input
+	var resumedDomains []*domainState
+	var resumedRequests []*ipGeneratorResult
+	var finishedDomains map[string]struct{}
+	resumeFrom := scanner.config.ResumeFrom
+	if resumeFrom != nil {
+		for taken < resumeFrom.InputLine {
+			if _, ok := domains.Next(); !ok {
+				break
+			}
+			taken++
+		}
+		resumedDomains, resumedRequests, err = scanner.restoreDomains(resumeFrom)
+		if err != nil {
+			return err
+		}
+		finishedDomains = scanner.finishedSet(resumeFrom)
+		scanner.stats.restore(resumeFrom.Stats)
+		if scanner.nsBudget != nil {
+			scanner.nsBudget.restore(resumeFrom.NSQueries)
+		}
+	}
+	var checkpoints *checkpointer
+	if scanner.config.CheckpointInterval > 0 {
+		checkpoints = &checkpointer{
+			scanner:   scanner,
+			dir:       scanner.config.CheckpointDir,
+			interval:  scanner.config.CheckpointInterval,
+			inputLine: func() int64 { return taken },
+		}
+		if resumeFrom != nil {
+			checkpoints.finished = resumeFrom.Finished
+		}
+	}
+	if scanner.config.StatsInterval > 0 {
+		statsDone := make(chan struct{})
+		defer close(statsDone)
+		go scanner.logStatisticsPeriodically(scanner.config.StatsInterval, statsDone)
+	}
+
+	// nextDomainStates returns the domain states of the next domain, one per family of the scan
+	nextDomainStates := func() []*domainState {
+		for {
+			domain, ok := domains.Next()
+			if !ok {
+				return nil
+			}
+			taken++
+			states := scanner.domainStates(domain, finishedDomains)
+			for _, state := range states {
+				state.inputLine = taken
+			}
+			if len(states) > 0 {
+				return states
+			}
+		}
+	}
+
+	if scanner.config.MaxDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, scanner.config.MaxDuration)
+		defer cancel()
+	}
+	scanner.controller(nextDomainStates, resumedDomains, resumedRequests, checkpoints, scanner.startScanners, ctx) //the actual magic starts
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	select {
+	case <-scanner.stop:
+		return ErrStopped
+	default:
+		return nil
+	}
+}
+
+/*
+Stop stops the scan gracefully, e.g. on an interrupt: no new domains are started and no new queries are handed to the scanners,
+while the queries they already got are still answered and written to the results. Once they returned, a last checkpoint is
+written and Run returns ErrStopped. Cancel the context of Run to abandon the outstanding queries instead of waiting for them.
+*/
+func (scanner *Scanner) Stop() {
+	scanner.stopOnce.Do(func() {
+		close(scanner.stop)
+	})
+}
+
+// start opens the sockets and connection pools of the transports
+func (scanner *Scanner) start() error {
+	if scanner.config.UDPSockets > 0 {
+		var err error
+		scanner.udpEngine, err = newUDPQueryEngine(scanner)
+		if err != nil {
+			return err
+		}
+	}
+	scanner.initTransports()
+	return nil
+}
+
+// domainStates returns the states of a domain, one per family of the scan, without those finished before resuming
+func (scanner *Scanner) domainStates(domain Domain, finishedDomains map[string]struct{}) []*domainState {
+	transport := domain.Transport
+	if transport == "" {
+		transport = scanner.config.Transport
+	}
+	if err := CheckTransport(transport); err != nil {
+		scanner.config.Logger.errorlog("Domain '%v': %s", domain.Name, err)
+		return nil
+	}
+	nameserverIP := domain.Nameserver
+	if nameserverIP == nil {
+		scanner.config.Logger.errorlog("Domain '%v' has no nameserver", domain.Name)
+		return nil
+	}
+	if ip4 := nameserverIP.To4(); ip4 != nil {
+		nameserverIP = ip4
+	}
+	qtype := domain.QueryType
+	if qtype == 0 {
+		qtype = scanner.config.QueryType
+	}
+	port := scanner.nameserverPort(domain.Port, transport)
+	var states []*domainState
+	for _, family := range scanner.scanFamilies() {
+		identifier := scanner.domainIdentifier(domain.Name, nameserverIP, port, transport, qtype, family)
+		if _, finished := finishedDomains[identifier]; finished {
+			scanner.config.Logger.debuglog("DOMAINSTATE: skipping %v as it was already scanned before resuming", identifier)
+			continue
+		}
+		states = append(states, &domainState{
+			scanner:        scanner,
+			domain:         domain.Name,
+			nameserverIP:   nameserverIP,
+			nameserverPort: port,
+			transport:      transport,
+			qtype:          qtype,
+			family:         family,
+			identifier:     identifier,
+		})
+	}
+	return states
+}
@@ -0,0 +1,168 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package scan
+
+import (
+	"net"
+	"net/netip"
+	"slices"
+
+	"github.com/miekg/dns"
+)
+
+// scopeMapEntry is a prefix the nameserver returned as scope, i.e. it treats all clients inside as one unit
+type scopeMapEntry struct {
+	prefix    ipPrefix // client address shortened to the scope (or the source prefix length if the scope was longer)
+	scope     byte     // smallest scope prefix length returned for this prefix
+	responses int      // number of responses with this prefix as scope
+	answers   []string // distinct answers seen inside this prefix
+}
+
+// recordScope adds a response with a scope to the scope map of a domain
+func (domainState *domainState) recordScope(prefix ipPrefix, scope byte, answers []string) {
+	if domainState.scopeMap == nil {
+		domainState.scopeMap = make(map[ipPrefix]*scopeMapEntry)
+	}
+	entry, ok := domainState.scopeMap[prefix]
+	if !ok {
+		entry = &scopeMapEntry{
+			prefix: prefix,
+			scope:  scope,
+		}
+		domainState.scopeMap[prefix] = entry
+	}
+	entry.responses++
+	if scope < entry.scope {
+		entry.scope = scope
+	}
+	entry.addAnswers(answers)
+}
+
+func (entry *scopeMapEntry) addAnswers(answers []string) {
+	for _, answer := range answers {
+		if !slices.Contains(entry.answers, answer) {
+			entry.answers = append(entry.answers, answer)
+		}
+	}
+}
+
+// coveringScopes returns the minimal set of prefixes covering all prefixes of the scope map,
+// the responses and answers of a more specific prefix are added to the prefix covering it
+func (domainState *domainState) coveringScopes() []*scopeMapEntry {
+	entries := make([]*scopeMapEntry, 0, len(domainState.scopeMap))
+	for _, entry := range domainState.scopeMap {
+		entries = append(entries, entry)
+	}
+	slices.SortFunc(entries, func(a, b *scopeMapEntry) int {
+		if a.prefix.length != b.prefix.length {
+			return a.prefix.length - b.prefix.length
+		}
+		return a.prefix.compare(b.prefix)
+	})
+
+	covering := make(map[ipPrefix]*scopeMapEntry)
+	var result []*scopeMapEntry
+	for _, entry := range entries {
+		var coveredBy *scopeMapEntry
+		for length := 0; length < entry.prefix.length && coveredBy == nil; length++ {
+			coveredBy = covering[entry.prefix.truncate(length)]
+		}
+		if coveredBy != nil {
+			coveredBy.responses += entry.responses
+			coveredBy.addAnswers(entry.answers)
+			continue
+		}
+		cover := &scopeMapEntry{
+			prefix:    entry.prefix,
+			scope:     entry.scope,
+			responses: entry.responses,
+			answers:   slices.Clone(entry.answers),
+		}
+		covering[entry.prefix] = cover
+		result = append(result, cover)
+	}
+	slices.SortFunc(result, func(a, b *scopeMapEntry) int {
+		return a.prefix.compare(b.prefix)
+	})
+	return result
+}
+
+// kindOfScopePrefix returns whether the prefix lies in special, BGP announced or unannounced address space
+func kindOfScopePrefix(family *addressFamily, prefix ipPrefix) string {
+	announced := false
+	for length := 1; length <= prefix.length; length++ {
+		if family.isSpecial(prefix.truncate(length)) {
+			return "special"
+		}
+		if family.isBGPannounced(prefix.truncate(length)) {
+			announced = true
+		}
+	}
+	if announced {
+		return "announced"
+	}
+	return "unannounced"
+}
+
+// ScopePrefix is a prefix covering the scope map of a domain as passed to the ResultSink, a row of the scope map file
+type ScopePrefix struct {
+	Domain            string
+	Nameserver        net.IP
+	Family            uint8        // ECS family, 1 for IPv4 and 2 for IPv6
+	Prefix            netip.Prefix // client address shortened to the scope (or the source prefix length if the scope was longer)
+	ScopePrefixLength byte         // smallest scope prefix length returned inside the prefix
+	Kind              string       // special, announced or unannounced address space
+	Responses         int          // number of responses with a scope inside the prefix
+	Answers           []string     // distinct answers seen inside the prefix
+	QueryType         uint16
+	FinishReason      string // why the scan of the domain ended, budgetExhausted or domainTimeout if the scope map is incomplete
+}
+
+// scopeMapColumns is the format of the scope map file
+var scopeMapColumns = []resultColumn[ScopePrefix]{
+	stringColumn("domain", func(r *ScopePrefix) string { return r.Domain }),
+	stringColumn("ns", func(r *ScopePrefix) string { return r.Nameserver.String() }),
+	uintColumn("family", func(r *ScopePrefix) uint64 { return uint64(r.Family) }),
+	stringColumn("prefix", func(r *ScopePrefix) string { return formatPrefix(r.Prefix) }),
+	uintColumn("scopePrefixLength", func(r *ScopePrefix) uint64 { return uint64(r.ScopePrefixLength) }),
+	stringColumn("kind", func(r *ScopePrefix) string { return r.Kind }),
+	intColumn("responses", func(r *ScopePrefix) int64 { return int64(r.Responses) }),
+	listColumn("answers", func(r *ScopePrefix) []string { return r.Answers }),
+	stringColumn("qtype", func(r *ScopePrefix) string { return dns.Type(r.QueryType).String() }),
+	stringColumn("finishReason", func(r *ScopePrefix) string { return r.FinishReason }),
+}
+
+// writeScopeMap passes the covering prefixes of a finished domain to the result sink
+func (scanner *Scanner) writeScopeMap(domainState *domainState) {
+	var qtype = dns.TypeA
+	if domainState.family.ipv6 {
+		qtype = dns.TypeAAAA
+	}
+	if domainState.qtype != 0 {
+		qtype = domainState.qtype
+	}
+	var prefixes []ScopePrefix
+	for _, entry := range domainState.coveringScopes() {
+		prefixes = append(prefixes, ScopePrefix{
+			Domain:            domainState.domain,
+			Nameserver:        domainState.nameserverIP,
+			Family:            domainState.family.number(),
+			Prefix:            entry.prefix.netipPrefix(domainState.family.ipv6),
+			ScopePrefixLength: entry.scope,
+			Kind:              kindOfScopePrefix(domainState.family, entry.prefix),
+			Responses:         entry.responses,
+			Answers:           entry.answers,
+			QueryType:         qtype,
+			FinishReason:      domainState.finishReason.String(),
+		})
+	}
+	if len(prefixes) == 0 {
+		return
+	}
+	err := scanner.config.Sink.WriteScopeMap(prefixes)
+	if err != nil {
+		scanner.config.Logger.errorlog("failed writing scope map for %s", domainState.domain)
+	}
+}
@@ -0,0 +1,213 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package scan
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// reasons why the scan of a domain was finished
+type finish_reason uint8
+
+const (
+	FINISHED_TRIE_EXHAUSTED = iota // all prefixes within the limits were scanned
+	FINISHED_PERM_ERROR
+	FINISHED_TEMP_ERRORS
+	FINISHED_SCOPE_ZERO       // too many responses with scope zero
+	FINISHED_LIST             // all prefixes of the query list were scanned
+	FINISHED_DOMAIN_TIMEOUT   // the domain ran out of its time budget
+	FINISHED_BUDGET_EXHAUSTED // the domain or its nameserver ran out of its query budget
+	NUM_FINISH_REASONS
+)
+
+var finishReasonNames = [NUM_FINISH_REASONS]string{
+	FINISHED_TRIE_EXHAUSTED:   "trieExhausted",
+	FINISHED_PERM_ERROR:       "permError",
+	FINISHED_TEMP_ERRORS:      "tempErrors",
+	FINISHED_SCOPE_ZERO:       "scopeZeroLimit",
+	FINISHED_LIST:             "listFinished",
+	FINISHED_DOMAIN_TIMEOUT:   "domainTimeout",
+	FINISHED_BUDGET_EXHAUSTED: "budgetExhausted",
+}
+
+func (reason finish_reason) String() string {
+	if reason >= NUM_FINISH_REASONS {
+		return "unknown"
+	}
+	return finishReasonNames[reason]
+}
+
+// scanStatistics counts the progress of the scan, it is updated by the controller and the scanners
+type scanStatistics struct {
+	domainsStarted  atomic.Int64
+	domainsFinished atomic.Int64
+	domainsAborted  atomic.Int64 // domains which were still outstanding when the scan was stopped
+	finishReasons   [NUM_FINISH_REASONS]atomic.Int64
+	queriesSent     atomic.Int64
+	retries         atomic.Int64
+	tcpFallbacks    atomic.Int64
+	backoffs        atomic.Int64                  // decreases of an adaptive rate
+	repeatedQueries atomic.Int64                  // queries repeated after backing off
+	responses       [NUM_ERROR_TYPES]atomic.Int64 // responses per error type
+	scopes          [256]atomic.Int64             // responses per scope prefix length, 255 if there was no ECS option
+
+	// only exported as metrics, they are not part of snapshots
+	limiterWaits       atomic.Int64 // queries which had to wait for a token of the rate limiter
+	limiterWaitTime    atomic.Int64 // nanoseconds spent waiting for tokens
+	outstandingDomains atomic.Int64
+	ipGeneratorBacklog atomic.Int64 // length of sliceIPGeneratorToController
+	scannerBacklog     atomic.Int64 // length of sliceScannerToController
+	rtt                rttHistogram
+}
+
+// upper bounds of the RTT histogram buckets in seconds
+var rttBuckets = [...]float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// rttHistogram counts the round trip times of all answered queries.
+// It takes a mutex instead of atomics so a scrape sees the buckets and the sum of the same queries.
+type rttHistogram struct {
+	mutex   sync.Mutex
+	buckets [len(rttBuckets) + 1]int64 // one per entry of rttBuckets and one for larger values
+	sum     time.Duration
+}
+
+func (histogram *rttHistogram) observe(rtt time.Duration) {
+	bucket := len(rttBuckets)
+	for i, bound := range rttBuckets {
+		if rtt.Seconds() <= bound {
+			bucket = i
+			break
+		}
+	}
+	histogram.mutex.Lock()
+	histogram.buckets[bucket]++
+	histogram.sum += rtt
+	histogram.mutex.Unlock()
+}
+
+// snapshot returns the counts of the buckets and the sum of the round trip times
+func (histogram *rttHistogram) snapshot() ([len(rttBuckets) + 1]int64, time.Duration) {
+	histogram.mutex.Lock()
+	defer histogram.mutex.Unlock()
+	return histogram.buckets, histogram.sum
+}
+
+// Statistics is a copy of the statistics of a scan as written to stats.json, the manifest and checkpoints
+type Statistics struct {
+	DomainsStarted          int64            `json:"domainsStarted"`
+	DomainsFinished         int64            `json:"domainsFinished"`
+	DomainsAborted          int64            `json:"domainsAborted"`
+	DomainsFinishedByReason map[string]int64 `json:"domainsFinishedByReason"`
+	QueriesSent             int64            `json:"queriesSent"`
+	Retries                 int64            `json:"retries"`
+	TCPFallbacks            int64            `json:"tcpFallbacks"`
+	Backoffs                int64            `json:"backoffs"`
+	RepeatedQueries         int64            `json:"repeatedQueries"`
+	Responses               map[string]int64 `json:"responses"`
+	ScopePrefixLengths      map[string]int64 `json:"scopePrefixLengths"`
+}
+
+// domainFinished counts a finished domain
+func (stats *scanStatistics) domainFinished(reason finish_reason) {
+	stats.domainsFinished.Add(1)
+	if reason < NUM_FINISH_REASONS {
+		stats.finishReasons[reason].Add(1)
+	}
+}
+
+// response counts the error type and scope of a response
+func (stats *scanStatistics) response(error ErrorType, scope byte) {
+	if error >= 0 && error < NUM_ERROR_TYPES {
+		stats.responses[error].Add(1)
+	}
+	stats.scopes[scope].Add(1)
+}
+
+func (stats *scanStatistics) snapshot() Statistics {
+	snapshot := Statistics{
+		DomainsStarted:          stats.domainsStarted.Load(),
+		DomainsFinished:         stats.domainsFinished.Load(),
+		DomainsAborted:          stats.domainsAborted.Load(),
+		DomainsFinishedByReason: make(map[string]int64),
+		QueriesSent:             stats.queriesSent.Load(),
+		Retries:                 stats.retries.Load(),
+		TCPFallbacks:            stats.tcpFallbacks.Load(),
+		Backoffs:                stats.backoffs.Load(),
+		RepeatedQueries:         stats.repeatedQueries.Load(),
+		Responses:               make(map[string]int64),
+		ScopePrefixLengths:      make(map[string]int64),
+	}
+	for reason := range stats.finishReasons {
+		snapshot.DomainsFinishedByReason[finish_reason(reason).String()] = stats.finishReasons[reason].Load()
+	}
+	for error := range stats.responses {
+		if count := stats.responses[error].Load(); count > 0 {
+			snapshot.Responses[ErrorType(error).String()] = count
+		}
+	}
+	for scope := range stats.scopes {
+		if count := stats.scopes[scope].Load(); count > 0 {
+			snapshot.ScopePrefixLengths[scopeName(scope)] = count
+		}
+	}
+	return snapshot
+}
+
+// restore sets the statistics to the values of a snapshot, used when resuming a scan
+func (stats *scanStatistics) restore(snapshot Statistics) {
+	stats.domainsStarted.Store(snapshot.DomainsStarted)
+	stats.domainsFinished.Store(snapshot.DomainsFinished)
+	stats.domainsAborted.Store(snapshot.DomainsAborted)
+	stats.queriesSent.Store(snapshot.QueriesSent)
+	stats.retries.Store(snapshot.Retries)
+	stats.tcpFallbacks.Store(snapshot.TCPFallbacks)
+	stats.backoffs.Store(snapshot.Backoffs)
+	stats.repeatedQueries.Store(snapshot.RepeatedQueries)
+	for reason := range stats.finishReasons {
+		stats.finishReasons[reason].Store(snapshot.DomainsFinishedByReason[finish_reason(reason).String()])
+	}
+	for error := range stats.responses {
+		stats.responses[error].Store(snapshot.Responses[ErrorType(error).String()])
+	}
+	for scope := range stats.scopes {
+		stats.scopes[scope].Store(snapshot.ScopePrefixLengths[scopeName(scope)])
+	}
+}
+
+func scopeName(scope int) string {
+	if scope == 255 {
+		return "none"
+	}
+	return strconv.Itoa(scope)
+}
+
+// Statistics returns the statistics of the scan so far
+func (scanner *Scanner) Statistics() Statistics {
+	return scanner.stats.snapshot()
+}
+
+// LogStatistics writes a summary of the statistics to the info log
+func (scanner *Scanner) LogStatistics() {
+	snapshot := scanner.stats.snapshot()
+	scanner.config.Logger.infolog("STATS: domains started=%v finished=%v aborted=%v, queries sent=%v retries=%v tcp fallbacks=%v, responses=%v, finished by reason=%v",
+		snapshot.DomainsStarted, snapshot.DomainsFinished, snapshot.DomainsAborted, snapshot.QueriesSent, snapshot.Retries, snapshot.TCPFallbacks, snapshot.Responses, snapshot.DomainsFinishedByReason)
+}
+
+// logStatisticsPeriodically logs the statistics in the given interval until done is closed
+func (scanner *Scanner) logStatisticsPeriodically(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			scanner.LogStatistics()
+		case <-done:
+			return
+		}
+	}
+}
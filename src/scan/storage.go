@@ -0,0 +1,296 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package scan
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression of the output files, selected by the file extension
+const (
+	COMPRESSION_NONE = ""
+	COMPRESSION_GZIP = "gzip"
+	COMPRESSION_ZSTD = "zstd"
+)
+
+var compressionExtensions = map[string]string{
+	COMPRESSION_GZIP: ".gz",
+	COMPRESSION_ZSTD: ".zst",
+}
+
+// Struct to allow synchronized writing to a file
+// If rotation is enabled the file is split into parts (e.g. ecsresults-000001.csv.zst), each starting with the header.
+type SynchronizedWriter struct {
+	filename    string // name of the file without part number, used to identify the writer in checkpoints
+	dir         string
+	header      string
+	compression string
+	numbered    bool // the file names contain the part number
+	rotateBytes int64
+	rotateRows  int64
+	part        int   // current part, starting at 1 for numbered files
+	partRows    int64 // records written to the current part
+	file        *os.File
+	fileCounter *countingWriter
+	compressor  io.WriteCloser
+	fileWriter  *bufio.Writer
+	mutex       sync.Mutex
+	closed      bool
+}
+
+// writerPosition is the position of a writer in a checkpoint
+type writerPosition struct {
+	Part int
+	Size int64 // bytes of the part on disk, for compressed parts always 0 as they are recreated on resume
+	Rows int64
+}
+
+// countingWriter counts the bytes written to the file to rotate by size
+type countingWriter struct {
+	writer io.Writer
+	count  int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.writer.Write(p)
+	c.count += int64(n)
+	return n, err
+}
+
+// compressionOfFile returns the compression selected by the extension of the file name
+func compressionOfFile(filename string) string {
+	for compression, extension := range compressionExtensions {
+		if strings.HasSuffix(filename, extension) {
+			return compression
+		}
+	}
+	return COMPRESSION_NONE
+}
+
+func newSynchronizedWriter(config *FileSinkConfig, filename string, header string) *SynchronizedWriter {
+	syncWriter := new(SynchronizedWriter)
+	syncWriter.filename = filename
+	syncWriter.dir = config.Dir
+	syncWriter.header = header
+	syncWriter.compression = compressionOfFile(filename)
+	syncWriter.rotateBytes = config.RotateBytes
+	syncWriter.rotateRows = config.RotateRows
+	// compressed files cannot be truncated on resume, so checkpoints start a new part
+	syncWriter.numbered = config.RotateBytes > 0 || config.RotateRows > 0 || (config.Checkpoints && syncWriter.compression != COMPRESSION_NONE)
+	if syncWriter.numbered {
+		syncWriter.part = 1
+	}
+	return syncWriter
+}
+
+// Set up a new writer and write header in the first line
+func SetupSynchronizedWriter(config *FileSinkConfig, filename string, header string) (*SynchronizedWriter, error) {
+	syncWriter := newSynchronizedWriter(config, filename, header)
+	err := syncWriter.openPart(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("can't create file %v: %w", syncWriter.partPath(syncWriter.part), err)
+	}
+	return syncWriter, nil
+}
+
+// Reopen a writer of a resumed scan, everything after the position is discarded
+func ResumeSynchronizedWriter(config *FileSinkConfig, filename string, header string, position writerPosition) (*SynchronizedWriter, error) {
+	syncWriter := newSynchronizedWriter(config, filename, header)
+	syncWriter.part = position.Part
+	if syncWriter.compression != COMPRESSION_NONE {
+		// compressed files can't be appended to, they are continued in a new part even if the resumed scan writes no checkpoints
+		syncWriter.numbered = true
+	}
+	if syncWriter.numbered && syncWriter.part == 0 {
+		// the checkpoint has no position of the file, it is continued after its last part
+		syncWriter.part = syncWriter.lastPart() + 1
+	}
+
+	// parts written after the checkpoint are removed
+	for part := syncWriter.part + 1; syncWriter.numbered; part++ {
+		err := os.Remove(syncWriter.partPath(part))
+		if os.IsNotExist(err) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("can't remove file %v: %w", syncWriter.partPath(part), err)
+		}
+	}
+
+	err := syncWriter.openPart(position.Size, position.Rows)
+	if err != nil {
+		return nil, fmt.Errorf("can't reopen file %v: %w", syncWriter.partPath(syncWriter.part), err)
+	}
+	return syncWriter, nil
+}
+
+// partPath returns the path of a part, e.g. ecsresults-000001.csv.zst
+func (w *SynchronizedWriter) partPath(part int) string {
+	if !w.numbered {
+		return filepath.Join(w.dir, w.filename)
+	}
+	base, extension, _ := strings.Cut(w.filename, ".")
+	return filepath.Join(w.dir, fmt.Sprintf("%s-%06d.%s", base, part, extension))
+}
+
+// lastPart returns the highest part number of the file in the directory, 0 if it has no parts
+func (w *SynchronizedWriter) lastPart() int {
+	part := 0
+	for {
+		_, err := os.Stat(w.partPath(part + 1))
+		if err != nil {
+			return part
+		}
+		part++
+	}
+}
+
+// openPart opens the current part, if keep is larger than 0 the first keep bytes of an existing file are kept and appended to
+func (w *SynchronizedWriter) openPart(keep int64, rows int64) error {
+	var f *os.File
+	var err error
+	if keep > 0 {
+		f, err = os.OpenFile(w.partPath(w.part), os.O_WRONLY, 0)
+		if err == nil {
+			err = f.Truncate(keep)
+		}
+		if err == nil {
+			_, err = f.Seek(keep, io.SeekStart)
+		}
+	} else {
+		f, err = os.Create(w.partPath(w.part))
+	}
+	if err != nil {
+		return err
+	}
+
+	w.file = f
+	w.fileCounter = &countingWriter{writer: f, count: keep}
+	w.partRows = rows
+	switch w.compression {
+	case COMPRESSION_GZIP:
+		w.compressor = gzip.NewWriter(w.fileCounter)
+		w.fileWriter = bufio.NewWriter(w.compressor)
+	case COMPRESSION_ZSTD:
+		w.compressor, err = zstd.NewWriter(w.fileCounter)
+		if err != nil {
+			return err
+		}
+		w.fileWriter = bufio.NewWriter(w.compressor)
+	default:
+		w.compressor = nil
+		w.fileWriter = bufio.NewWriter(w.fileCounter)
+	}
+	if keep == 0 && w.header != "" {
+		_, err = w.fileWriter.WriteString(w.header + "\n")
+	}
+	return err
+}
+
+// closePart flushes all buffers, ends the compressed stream and syncs the current part to disk
+func (w *SynchronizedWriter) closePart() error {
+	err := w.fileWriter.Flush()
+	if err != nil {
+		return err
+	}
+	if w.compressor != nil {
+		err = w.compressor.Close()
+		if err != nil {
+			return err
+		}
+	}
+	err = w.file.Sync()
+	if err != nil {
+		return err
+	}
+	return w.file.Close()
+}
+
+// rotate closes the current part and starts the next one, the lock must be held
+func (w *SynchronizedWriter) rotate() error {
+	err := w.closePart()
+	if err != nil {
+		return err
+	}
+	w.part++
+	return w.openPart(0, 0)
+}
+
+// write a singular line to file
+// adds a newline
+func (w *SynchronizedWriter) writeAsLine(line string) error {
+	return w.writeRecord([]byte(line + "\n"))
+}
+
+// write already formatted records, a record is never split between two parts
+func (w *SynchronizedWriter) writeRecord(record []byte) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return os.ErrClosed
+	}
+	_, err := w.fileWriter.Write(record)
+	if err != nil {
+		return err
+	}
+	w.partRows += int64(bytes.Count(record, []byte{'\n'}))
+	if (w.rotateRows > 0 && w.partRows >= w.rotateRows) || (w.rotateBytes > 0 && w.fileCounter.count+int64(w.fileWriter.Buffered()) >= w.rotateBytes) {
+		return w.rotate()
+	}
+	return nil
+}
+
+// Close flushes the buffered lines, syncs the file to disk and closes it.
+// Writes after Close return os.ErrClosed, calling Close twice is a no-op.
+func (w *SynchronizedWriter) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	err := w.closePart()
+	if err != nil {
+		return fmt.Errorf("closing file %s: %w", w.partPath(w.part), err)
+	}
+	return nil
+}
+
+// checkpointPosition flushes the writer and returns the position up to which the output is safely on disk.
+// Compressed files cannot be continued after a crash, so the current part is finished and a new one is started.
+func (w *SynchronizedWriter) checkpointPosition() (writerPosition, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return writerPosition{}, os.ErrClosed
+	}
+	if w.compression != COMPRESSION_NONE {
+		if w.partRows > 0 {
+			err := w.rotate()
+			if err != nil {
+				return writerPosition{}, err
+			}
+		}
+		return writerPosition{Part: w.part}, nil
+	}
+	err := w.fileWriter.Flush()
+	if err != nil {
+		return writerPosition{}, err
+	}
+	err = w.file.Sync()
+	if err != nil {
+		return writerPosition{}, err
+	}
+	return writerPosition{Part: w.part, Size: w.fileCounter.count, Rows: w.partRows}, nil
+}
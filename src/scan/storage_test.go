@@ -0,0 +1,69 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package scan
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// A compressed file is continued in numbered parts on resume even if the resumed scan writes no checkpoints
+func TestResumeCompressedWithoutCheckpoints(t *testing.T) {
+	dir := t.TempDir()
+	parts := func() []string {
+		names, err := filepath.Glob(filepath.Join(dir, "ecsresults*"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range names {
+			names[i] = filepath.Base(names[i])
+		}
+		slices.Sort(names)
+		return names
+	}
+	config := &FileSinkConfig{Dir: dir, Compression: COMPRESSION_GZIP, Checkpoints: true}
+	w, err := SetupSynchronizedWriter(config, "ecsresults.csv.gz", "header")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.writeAsLine("before")
+	position, err := w.checkpointPosition()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.writeAsLine("after")
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	config.Checkpoints = false
+	w, err = ResumeSynchronizedWriter(config, "ecsresults.csv.gz", "header", position)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := parts(); !slices.Equal(got, []string{"ecsresults-000001.csv.gz", "ecsresults-000002.csv.gz"}) {
+		t.Errorf("resuming at part %v left the files %v", position.Part, got)
+	}
+
+	// without a position of the file the parts are continued after the last one
+	w, err = ResumeSynchronizedWriter(config, "ecsresults.csv.gz", "header", writerPosition{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := parts(); len(got) != 3 || got[2] != "ecsresults-000003.csv.gz" {
+		t.Errorf("resuming without a position left the files %v", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "ecsresults.csv.gz")); err == nil {
+		t.Error("an unnumbered file was created")
+	}
+}
@@ -0,0 +1,272 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package scan
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+var errStreamTimeout = errors.New("stream query timed out")
+var errStreamPoolClosed = errors.New("stream connection closed as the scan finished")
+
+// streamPool keeps TCP or TLS connections to the nameservers open and pipelines the queries of all scanners over them, RFC 7766.
+// A nameserver gets another connection once Config.StreamPipeline queries are outstanding on its connections.
+// Connections without outstanding queries are closed after Config.StreamIdleTimeout.
+type streamPool struct {
+	mutex  sync.Mutex
+	conns  map[string][]*streamConn
+	dial   func(ctx context.Context, server string) (net.Conn, error)
+	config *Config
+	done   chan struct{} // closed to stop closeIdle
+}
+
+type streamConn struct {
+	pool       *streamPool
+	server     string
+	conn       *dns.Conn
+	writeMutex sync.Mutex
+	mutex      sync.Mutex
+	pending    map[uint16]*streamQuery
+	closed     bool
+	lastUsed   time.Time
+}
+
+type streamQuery struct {
+	question dns.Question
+	done     chan streamResult
+}
+
+type streamResult struct {
+	response *dns.Msg
+	err      error
+}
+
+func newStreamPool(config *Config, dial func(ctx context.Context, server string) (net.Conn, error)) *streamPool {
+	pool := &streamPool{
+		conns:  make(map[string][]*streamConn),
+		dial:   dial,
+		config: config,
+		done:   make(chan struct{}),
+	}
+	go pool.closeIdle()
+	return pool
+}
+
+// exchange sends the query over a connection to the server and waits for the response or until ctx is done
+func (pool *streamPool) exchange(ctx context.Context, msg *dns.Msg, server string) (*dns.Msg, time.Duration, error) {
+	query := &streamQuery{question: msg.Question[0], done: make(chan streamResult, 1)}
+	conn, id, err := pool.connection(ctx, server, query)
+	if err != nil {
+		return nil, 0, err
+	}
+	msg.Id = id
+
+	conn.writeMutex.Lock()
+	conn.conn.SetWriteDeadline(time.Now().Add(pool.config.TimeoutWrite))
+	err = conn.conn.WriteMsg(msg)
+	conn.writeMutex.Unlock()
+	if err != nil {
+		conn.close(err)
+		return nil, 0, err
+	}
+	sent := time.Now()
+
+	timer := time.NewTimer(pool.config.TimeoutRead)
+	defer timer.Stop()
+	select {
+	case result := <-query.done:
+		return result.response, time.Since(sent), result.err
+	case <-timer.C:
+		conn.remove(id)
+		return nil, 0, errStreamTimeout
+	case <-ctx.Done():
+		conn.remove(id)
+		return nil, 0, ctx.Err()
+	}
+}
+
+// connection registers the query on a connection to the server with room for it and returns the connection and the ID
+// of the query. It dials a new connection if there is none, which holds the query before other scanners can see it.
+func (pool *streamPool) connection(ctx context.Context, server string, query *streamQuery) (*streamConn, uint16, error) {
+	pool.mutex.Lock()
+	for _, conn := range pool.conns[server] {
+		if id, ok := conn.add(query); ok {
+			pool.mutex.Unlock()
+			return conn, id, nil
+		}
+	}
+	pool.mutex.Unlock()
+
+	netConn, err := pool.dial(ctx, server)
+	if err != nil {
+		return nil, 0, err
+	}
+	pool.config.Logger.debuglog("STREAMPOOL: Opened connection to %v", server)
+	conn := &streamConn{
+		pool:     pool,
+		server:   server,
+		conn:     &dns.Conn{Conn: netConn},
+		pending:  make(map[uint16]*streamQuery),
+		lastUsed: time.Now(),
+	}
+	// Config.StreamPipeline is at least 1, a new connection always has room
+	id, _ := conn.add(query)
+	pool.mutex.Lock()
+	pool.conns[server] = append(pool.conns[server], conn)
+	pool.mutex.Unlock()
+	go conn.read()
+	return conn, id, nil
+}
+
+func (pool *streamPool) removeConnection(conn *streamConn) {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	conns := pool.conns[conn.server]
+	for i := range conns {
+		if conns[i] == conn {
+			conns = append(conns[:i], conns[i+1:]...)
+			break
+		}
+	}
+	if len(conns) == 0 {
+		delete(pool.conns, conn.server)
+	} else {
+		pool.conns[conn.server] = conns
+	}
+}
+
+// closeIdle closes the connections which had no outstanding queries for Config.StreamIdleTimeout
+func (pool *streamPool) closeIdle() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-pool.done:
+			return
+		}
+		var idle []*streamConn
+		pool.mutex.Lock()
+		for _, conns := range pool.conns {
+			for _, conn := range conns {
+				if conn.markIdle(pool.config.StreamIdleTimeout) {
+					idle = append(idle, conn)
+				}
+			}
+		}
+		pool.mutex.Unlock()
+		for _, conn := range idle {
+			pool.config.Logger.debuglog("STREAMPOOL: Closing idle connection to %v", conn.server)
+			conn.conn.Close()
+			pool.removeConnection(conn)
+		}
+	}
+}
+
+// close closes all connections and stops closing idle ones, it is called once the scan finished
+func (pool *streamPool) close() {
+	close(pool.done)
+	pool.mutex.Lock()
+	var conns []*streamConn
+	for _, serverConns := range pool.conns {
+		conns = append(conns, serverConns...)
+	}
+	pool.mutex.Unlock()
+	for _, conn := range conns {
+		conn.close(errStreamPoolClosed)
+	}
+}
+
+// add registers the query under an ID which is unique among the outstanding queries of the connection.
+// It reports false if the connection is closed or already carries Config.StreamPipeline queries.
+func (conn *streamConn) add(query *streamQuery) (uint16, bool) {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	if conn.closed || len(conn.pending) >= conn.pool.config.StreamPipeline {
+		return 0, false
+	}
+	id := dns.Id()
+	for conn.pending[id] != nil {
+		id = dns.Id()
+	}
+	conn.pending[id] = query
+	conn.lastUsed = time.Now()
+	return id, true
+}
+
+// markIdle marks the connection as closed if it had no outstanding queries for the timeout,
+// no query is added to it afterwards
+func (conn *streamConn) markIdle(timeout time.Duration) bool {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	if conn.closed || len(conn.pending) > 0 || time.Since(conn.lastUsed) <= timeout {
+		return false
+	}
+	conn.closed = true
+	return true
+}
+
+func (conn *streamConn) remove(id uint16) {
+	conn.mutex.Lock()
+	delete(conn.pending, id)
+	conn.mutex.Unlock()
+}
+
+// close closes the connection and fails its outstanding queries
+func (conn *streamConn) close(err error) {
+	conn.mutex.Lock()
+	if conn.closed {
+		conn.mutex.Unlock()
+		return
+	}
+	conn.closed = true
+	pending := conn.pending
+	conn.pending = nil
+	conn.mutex.Unlock()
+
+	conn.conn.Close()
+	conn.pool.removeConnection(conn)
+	for _, query := range pending {
+		query.done <- streamResult{err: err}
+	}
+}
+
+// read matches the responses on the connection to the outstanding queries until the connection is closed
+func (conn *streamConn) read() {
+	buffer := make([]byte, dns.MaxMsgSize)
+	for {
+		n, err := conn.conn.Read(buffer)
+		if err != nil {
+			conn.close(err)
+			return
+		}
+		response := new(dns.Msg)
+		err = response.Unpack(buffer[:n])
+		if err != nil {
+			conn.pool.config.Logger.debuglog("STREAMPOOL: Dropping response from %v which can't be parsed: %s", conn.server, err)
+			continue
+		}
+		conn.mutex.Lock()
+		query, ok := conn.pending[response.Id]
+		if ok && questionMatches(response, query.question) {
+			delete(conn.pending, response.Id)
+			conn.lastUsed = time.Now()
+		} else {
+			ok = false
+		}
+		conn.mutex.Unlock()
+		if !ok {
+			conn.pool.config.Logger.debuglog("STREAMPOOL: Dropping unexpected response %v from %v", response.Id, conn.server)
+			continue
+		}
+		query.done <- streamResult{response: response}
+	}
+}
@@ -0,0 +1,210 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package scan
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Controller types
+
+// ControllerQueue struct is used to receive results in the Controller
+type ControllerQueue struct {
+	condition                    *sync.Cond
+	sliceIPGeneratorToController []*ipGeneratorResult
+	sliceScannerToController     []*dnsResult
+	stopRequested                atomic.Bool // set on shutdown, the controller no longer admits domains or sends queries
+	checkpointRequested          atomic.Bool // set when the controller should write a checkpoint once all outstanding queries returned
+}
+
+// requestStop tells the controller and the scanners to wind down the scan
+func (controllerQueue *ControllerQueue) requestStop() {
+	controllerQueue.stopRequested.Store(true)
+	controllerQueue.wakeUp()
+}
+
+// requestCheckpoint tells the controller to write a checkpoint
+func (controllerQueue *ControllerQueue) requestCheckpoint() {
+	controllerQueue.checkpointRequested.Store(true)
+	controllerQueue.wakeUp()
+}
+
+// addScanResult hands the responses of a request to the controller
+func (controllerQueue *ControllerQueue) addScanResult(result *dnsResult) {
+	controllerQueue.condition.L.Lock()
+	controllerQueue.sliceScannerToController = append(controllerQueue.sliceScannerToController, result)
+	controllerQueue.condition.Signal()
+	controllerQueue.condition.L.Unlock()
+}
+
+func (controllerQueue *ControllerQueue) wakeUp() {
+	controllerQueue.condition.L.Lock()
+	controllerQueue.condition.Broadcast()
+	controllerQueue.condition.L.Unlock()
+}
+
+///// IPGENERATOR Types /////
+
+// kinds of the answers of a generator
+type resultKind uint8
+
+const (
+	RESULT_WAITING  resultKind = iota // nothing to send while responses are outstanding
+	RESULT_QUERY                      // a single query, the nameserver scheduler takes its token
+	RESULT_LIST                       // queries sent one after another by one scanner, which takes their tokens
+	RESULT_FINISHED                   // the scan of the domain is finished, its finish reason is set
+)
+
+// ipGeneratorResult is the answer of a generator for one domain. It is only created with the constructor of its kind,
+// singleQuery, queryRequestList, waitingForMoreResults or domainScanFinished, so only queries and lists carry queries.
+type ipGeneratorResult struct {
+	kind        resultKind
+	domainState *domainState
+	queries     []*queryRequest // one for a single query, at least one for a list, none otherwise
+}
+
+func singleQuery(request *queryRequest) *ipGeneratorResult {
+	return &ipGeneratorResult{kind: RESULT_QUERY, domainState: request.domainState, queries: []*queryRequest{request}}
+}
+
+// queryRequestList sends queries of a domain one after another, an empty list waits for more results
+func queryRequestList(domainState *domainState, requests []*queryRequest) *ipGeneratorResult {
+	if len(requests) == 0 {
+		return waitingForMoreResults(domainState)
+	}
+	return &ipGeneratorResult{kind: RESULT_LIST, domainState: domainState, queries: requests}
+}
+
+func waitingForMoreResults(domainState *domainState) *ipGeneratorResult {
+	return &ipGeneratorResult{kind: RESULT_WAITING, domainState: domainState}
+}
+
+func domainScanFinished(domainState *domainState) *ipGeneratorResult {
+	return &ipGeneratorResult{kind: RESULT_FINISHED, domainState: domainState}
+}
+
+// domainIdentifier names a domain-nameserver pair, the port, query type and transport are only added if they are not the default.
+// In a dual stack scan the family of the client subnets is added as every pair is scanned twice.
+func (scanner *Scanner) domainIdentifier(domain string, nameserverIP net.IP, port int, transport string, qtype uint16, family *addressFamily) string {
+	identifier := domain + nameserverIP.String()
+	if port != transportPorts[transport] {
+		identifier += ":" + strconv.Itoa(port)
+	}
+	if qtype != 0 {
+		identifier += "/" + dns.Type(qtype).String()
+	}
+	if transport != TRANSPORT_UDP {
+		identifier += "@" + transport
+	}
+	if scanner.config.DualStack {
+		identifier += "/" + family.name
+	}
+	return identifier
+}
+
+type queryRequest struct { // queryRequest contains the newly created parameters for a new EDNS request
+	ipAddressClient    net.IP // IPv4 or IPv6 Address that will be seen in EDNS CS extension.
+	sourcePrefixLength byte   // leftmost number of significant bits of ipAddressClient that can be used. the other bits of ipAddressClient must be padded with 0, according to RFC7871
+	family             byte   // indicates the type of AddressFamily. Is in fact 2 bytes long in the ECS extension. Relevant for us are only IPv4 (=1) and IPv6 (=2)
+	domainState        *domainState
+}
+
+func (request *queryRequest) isNil() bool {
+	erg := false
+	if request.ipAddressClient == nil {
+		erg = true
+	}
+	return erg
+}
+
+func (request *queryRequest) printNewRequest() {
+	fmt.Println("		EDNS REQUEST Parameters (domain: '",
+		request.domainState.domain,
+		"', subnet: '",
+		request.ipAddressClient, "/", request.sourcePrefixLength,
+		"')")
+}
+
+///// SCANNER Types /////
+
+// dnsResult is the answer of a scanner to the queries of an ipGeneratorResult
+type dnsResult struct {
+	domainState *domainState
+	responses   []*queryResponse
+	unsent      *ipGeneratorResult // queries which were not sent or not answered because the scan was cancelled
+	expired     int                // queries which were not sent because the time budget of the domain ran out
+}
+
+type queryResponse struct { //queryResponse contains the relevant content of one single DNS request and the corresponding DNS response.
+	request           *queryRequest
+	scopePrefixLength byte //leftmost number of bits the Authoritative NameServer wants to use
+	error             ErrorType
+	answers           []string
+	result            *Result // row of the result file
+}
+
+func (response *queryResponse) printRequestAndResponse() {
+	fmt.Println("		Scan Result (domain: '",
+		response.request.domainState.domain,
+		"', client subnet: '",
+		response.request.ipAddressClient, "/", response.request.sourcePrefixLength,
+		"', error: '",
+		response.error,
+		"', scope prefix length: '",
+		response.scopePrefixLength,
+		"')")
+}
+
+///// Global State types /////
+
+type domainState struct { //domainState contains the Trie that represents the scanned IP addresses for one domain
+	scanner           *Scanner
+	domain            string
+	nameserverIP      net.IP
+	nameserverPort    int
+	transport         string         // transport of the queries, see -transport
+	qtype             uint16         // query type, 0 to query A or AAAA depending on the ECS family
+	family            *addressFamily // family of the client subnets
+	identifier        string
+	inputLine         int64 // number of domains taken from the input up to and including this one, 0 if it was resumed
+	tempErrors        uint8
+	permError         bool
+	generator         subnetGenerator // chooses the client subnets, created by the ip generator on the first request
+	state             *root
+	listResponseIndex int
+	listScanIndex     int
+	scopeMap          map[ipPrefix]*scopeMapEntry // prefixes returned as scope
+	finishReason      finish_reason
+	deadline          time.Time // end of the time budget of the domain, zero without one
+	inFlight          int       // queries created by the generator without a response yet
+	queries           int       // queries created by the generator, counted against Config.MaxQueriesPerDomain
+	budgetExhausted   bool      // the generator wanted more queries than the budgets of the domain and its nameserver allowed
+}
+
+type ipGeneratorRequest struct {
+	domainState *domainState
+	lastScans   []*queryResponse
+	expired     int // queries dropped by the scanners as the time budget of the domain ran out
+}
+
+// timedOut reports whether the domain ran out of its time budget, see Config.DomainTimeout
+func (domainState *domainState) timedOut() bool {
+	return !domainState.deadline.IsZero() && time.Now().After(domainState.deadline)
+}
+
+// queryList returns the prefixes of the query list to scan the domain with, in a dual stack scan only those of its family
+func (domainState *domainState) queryList() []net.IPNet {
+	if domainState.scanner.config.DualStack {
+		return domainState.family.queryList
+	}
+	return domainState.scanner.queryList
+}
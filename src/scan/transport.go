@@ -0,0 +1,153 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package scan
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Transports of a query
+const (
+	TRANSPORT_UDP   = "udp"
+	TRANSPORT_TCP   = "tcp"
+	TRANSPORT_TLS   = "tls"   // DNS over TLS, RFC 7858
+	TRANSPORT_HTTPS = "https" // DNS over HTTPS, RFC 8484
+	TRANSPORT_QUIC  = "quic"  // DNS over QUIC, RFC 9250
+)
+
+// transportPorts are the default nameserver ports of the transports
+var transportPorts = map[string]int{
+	TRANSPORT_UDP:   53,
+	TRANSPORT_TCP:   53,
+	TRANSPORT_TLS:   853,
+	TRANSPORT_HTTPS: 443,
+	TRANSPORT_QUIC:  853,
+}
+
+// CheckTransport returns an error if queries can't be sent over the transport
+func CheckTransport(transport string) error {
+	switch transport {
+	case TRANSPORT_UDP, TRANSPORT_TCP, TRANSPORT_TLS, TRANSPORT_HTTPS:
+		return nil
+	case TRANSPORT_QUIC:
+		return errors.New("DNS over QUIC is not supported yet, use udp, tcp, tls or https")
+	}
+	return fmt.Errorf("unknown transport '%v', use udp, tcp, tls or https", transport)
+}
+
+// initTransports creates the connection pools of the stream transports, connections are opened on the first query
+func (scanner *Scanner) initTransports() {
+	scanner.tcpPool = newStreamPool(&scanner.config, func(ctx context.Context, server string) (net.Conn, error) {
+		return scanner.streamDialer().DialContext(ctx, "tcp", server)
+	})
+	scanner.tlsPool = newStreamPool(&scanner.config, func(ctx context.Context, server string) (net.Conn, error) {
+		dialer := &tls.Dialer{NetDialer: scanner.streamDialer(), Config: scanner.tlsConfig()}
+		return dialer.DialContext(ctx, "tcp", server)
+	})
+	scanner.dohTransport = scanner.newDoHClient()
+}
+
+// closeTransports closes the sockets and connections of the transports and stops their goroutines
+func (scanner *Scanner) closeTransports() {
+	if scanner.udpEngine != nil {
+		scanner.udpEngine.close()
+	}
+	scanner.tcpPool.close()
+	scanner.tlsPool.close()
+	scanner.dohTransport.client.CloseIdleConnections()
+}
+
+func (scanner *Scanner) streamDialer() *net.Dialer {
+	dialer := &net.Dialer{Timeout: scanner.config.TimeoutDial}
+	if scanner.config.LocalAddress != nil {
+		dialer.LocalAddr = &net.TCPAddr{IP: scanner.config.LocalAddress}
+	}
+	return dialer
+}
+
+// tlsConfig verifies the certificate of the nameserver against Config.TLSServerName or its IP address
+func (scanner *Scanner) tlsConfig() *tls.Config {
+	return &tls.Config{
+		ServerName:         scanner.config.TLSServerName,
+		InsecureSkipVerify: scanner.config.TLSInsecure,
+	}
+}
+
+// dohClient sends queries as POST requests, the HTTP client keeps the connections open and multiplexes them over HTTP/2
+type dohClient struct {
+	client     *http.Client
+	path       string
+	serverName string
+}
+
+func (scanner *Scanner) newDoHClient() *dohClient {
+	config := &scanner.config
+	dialer := scanner.streamDialer()
+	transport := &http.Transport{
+		DialContext:         dialer.DialContext,
+		TLSClientConfig:     scanner.tlsConfig(),
+		TLSHandshakeTimeout: config.TimeoutDial,
+		ForceAttemptHTTP2:   true,
+		MaxIdleConnsPerHost: config.StreamPipeline,
+		IdleConnTimeout:     config.StreamIdleTimeout,
+	}
+	return &dohClient{
+		client:     &http.Client{Transport: transport, Timeout: config.TimeoutDial + config.TimeoutWrite + config.TimeoutRead},
+		path:       config.DoHPath,
+		serverName: config.TLSServerName,
+	}
+}
+
+func (doh *dohClient) exchange(ctx context.Context, msg *dns.Msg, server string) (*dns.Msg, time.Duration, error) {
+	// the ID is 0 so caches in front of the server see identical requests, RFC 8484 4.1
+	msg.Id = 0
+	packet, err := msg.Pack()
+	if err != nil {
+		return nil, 0, err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://"+server+doh.path, bytes.NewReader(packet))
+	if err != nil {
+		return nil, 0, err
+	}
+	request.Header.Set("Content-Type", "application/dns-message")
+	request.Header.Set("Accept", "application/dns-message")
+	if doh.serverName != "" {
+		request.Host = doh.serverName
+	}
+
+	start := time.Now()
+	httpResponse, err := doh.client.Do(request)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer httpResponse.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(httpResponse.Body, dns.MaxMsgSize))
+	rtt := time.Since(start)
+	if err != nil {
+		return nil, 0, err
+	}
+	if httpResponse.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("doh server answered with status %v", httpResponse.Status)
+	}
+	response := new(dns.Msg)
+	err = response.Unpack(body)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !questionMatches(response, msg.Question[0]) {
+		return nil, 0, errors.New("doh response does not match the question")
+	}
+	return response, rtt, nil
+}
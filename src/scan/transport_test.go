@@ -0,0 +1,237 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package scan
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// answerName answers every query with an A record for its name after a short delay, so queries overlap on the connections
+func answerName(query *dns.Msg) *dns.Msg {
+	time.Sleep(5 * time.Millisecond)
+	response := new(dns.Msg).SetReply(query)
+	response.Answer = append(response.Answer, &dns.A{
+		Hdr: dns.RR_Header{Name: query.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+		A:   net.IPv4(192, 0, 2, 1),
+	})
+	return response
+}
+
+// newDoHServer starts a DoH stand-in answering with answerName
+func newDoHServer(t *testing.T) *httptest.Server {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		query := new(dns.Msg)
+		if err == nil {
+			err = query.Unpack(body)
+		}
+		if err != nil || r.URL.Path != "/dns-query" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		packet, _ := answerName(query).Pack()
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(packet)
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+// countingListener counts the connections accepted by the DoT stand-in
+type countingListener struct {
+	net.Listener
+	mutex    sync.Mutex
+	accepted int
+}
+
+func (listener *countingListener) Accept() (net.Conn, error) {
+	conn, err := listener.Listener.Accept()
+	if err == nil {
+		listener.mutex.Lock()
+		listener.accepted++
+		listener.mutex.Unlock()
+	}
+	return conn, err
+}
+
+// newDoTServer starts a DoT stand-in answering with answerName, it uses the certificate of the httptest server
+func newDoTServer(t *testing.T, certificates []tls.Certificate) (string, *countingListener) {
+	tlsListener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: certificates})
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener := &countingListener{Listener: tlsListener}
+	server := &dns.Server{
+		Listener:      listener,
+		MaxTCPQueries: -1,
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, query *dns.Msg) {
+			w.WriteMsg(answerName(query))
+		}),
+	}
+	go server.ActivateAndServe()
+	t.Cleanup(func() { server.Shutdown() })
+	return tlsListener.Addr().String(), listener
+}
+
+func newTransportScanner(t *testing.T, pipeline int) *Scanner {
+	t.Helper()
+	config := DefaultConfig()
+	config.Sink = discardSink{}
+	config.TLSInsecure = true
+	config.StreamPipeline = pipeline
+	scanner, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = scanner.start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(scanner.closeTransports)
+	return scanner
+}
+
+// exchangeConcurrently sends the queries q0.example. to q<n-1>.example. at once and checks that every query gets its answer
+func exchangeConcurrently(t *testing.T, n int, exchange func(msg *dns.Msg) (*dns.Msg, time.Duration, error)) {
+	var wait sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			name := fmt.Sprintf("q%v.example.", i)
+			response, _, err := exchange(new(dns.Msg).SetQuestion(name, dns.TypeA))
+			if err != nil {
+				t.Errorf("%v: %v", name, err)
+				return
+			}
+			if len(response.Answer) != 1 || response.Answer[0].Header().Name != name {
+				t.Errorf("%v: got the answer %v", name, response.Answer)
+			}
+		}()
+	}
+	wait.Wait()
+}
+
+func TestStreamPoolDoT(t *testing.T) {
+	doh := newDoHServer(t)
+	server, listener := newDoTServer(t, doh.TLS.Certificates)
+	scanner := newTransportScanner(t, 4)
+
+	exchangeConcurrently(t, 64, func(msg *dns.Msg) (*dns.Msg, time.Duration, error) {
+		return scanner.tlsPool.exchange(context.Background(), msg, server)
+	})
+	listener.mutex.Lock()
+	accepted := listener.accepted
+	listener.mutex.Unlock()
+	if accepted < 64/4 {
+		t.Errorf("64 queries with a pipeline of 4 were sent over %v connections", accepted)
+	}
+
+	// the connections are reused
+	exchangeConcurrently(t, 4, func(msg *dns.Msg) (*dns.Msg, time.Duration, error) {
+		return scanner.tlsPool.exchange(context.Background(), msg, server)
+	})
+	listener.mutex.Lock()
+	defer listener.mutex.Unlock()
+	if listener.accepted != accepted {
+		t.Errorf("opened %v new connections with idle ones left", listener.accepted-accepted)
+	}
+}
+
+func TestDoHClient(t *testing.T) {
+	server := newDoHServer(t)
+	scanner := newTransportScanner(t, 4)
+	address := server.Listener.Addr().String()
+
+	exchangeConcurrently(t, 32, func(msg *dns.Msg) (*dns.Msg, time.Duration, error) {
+		return scanner.dohTransport.exchange(context.Background(), msg, address)
+	})
+
+	scanner.dohTransport.path = "/wrong"
+	_, _, err := scanner.dohTransport.exchange(context.Background(), new(dns.Msg).SetQuestion("a.example.", dns.TypeA), address)
+	if err == nil {
+		t.Error("expected an error for a status other than 200")
+	}
+}
+
+// Concurrent queries never exceed the pipeline of a connection: every query is registered on a connection with room
+// for it in one step, and new connections hold their first query before they are shared.
+func TestStreamPoolPipelineLimit(t *testing.T) {
+	config := DefaultConfig()
+	config.StreamPipeline = 2
+	var remotes []net.Conn // the server ends of the connections, they never answer
+	var mutex sync.Mutex
+	pool := newStreamPool(&config, func(ctx context.Context, server string) (net.Conn, error) {
+		local, remote := net.Pipe()
+		mutex.Lock()
+		remotes = append(remotes, remote)
+		mutex.Unlock()
+		return local, nil
+	})
+	defer pool.close()
+
+	var wait sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			query := &streamQuery{question: dns.Question{Name: "a.example.", Qtype: dns.TypeA, Qclass: dns.ClassINET}, done: make(chan streamResult, 1)}
+			_, _, err := pool.connection(context.Background(), "192.0.2.53:53", query)
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wait.Wait()
+
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	queries := 0
+	for _, conn := range pool.conns["192.0.2.53:53"] {
+		conn.mutex.Lock()
+		if len(conn.pending) > config.StreamPipeline {
+			t.Errorf("a connection carries %v queries with a pipeline of %v", len(conn.pending), config.StreamPipeline)
+		}
+		queries += len(conn.pending)
+		conn.mutex.Unlock()
+	}
+	if queries != 50 {
+		t.Errorf("%v of 50 queries are registered", queries)
+	}
+}
+
+// A connection closed as idle takes no more queries, which would otherwise be failed once it is closed
+func TestStreamConnIdle(t *testing.T) {
+	config := DefaultConfig()
+	pool := &streamPool{config: &config}
+	conn := &streamConn{pool: pool, pending: make(map[uint16]*streamQuery), lastUsed: time.Now().Add(-time.Minute)}
+	if !conn.markIdle(time.Second) {
+		t.Fatal("expected the connection to be idle")
+	}
+	if _, ok := conn.add(&streamQuery{}); ok {
+		t.Error("a query was added to a connection closed as idle")
+	}
+
+	conn = &streamConn{pool: pool, pending: make(map[uint16]*streamQuery), lastUsed: time.Now().Add(-time.Minute)}
+	if _, ok := conn.add(&streamQuery{}); !ok {
+		t.Fatal("expected the connection to take the query")
+	}
+	if conn.markIdle(0) {
+		t.Error("a connection with an outstanding query was closed as idle")
+	}
+}
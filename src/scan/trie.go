@@ -2,12 +2,10 @@
 // License, v. 2.0. If a copy of the MPL was not distributed with this
 // file, You can obtain one at https://mozilla.org/MPL/2.0/.
 
-package main
+package scan
 
 import (
 	"math/rand"
-	"slices"
-	"sort"
 )
 
 const (
@@ -17,59 +15,22 @@ const (
 	FINISHED_SCANNING
 )
 
-func isBGPannounced(prefix []uint8, isIPv6 bool) bool {
-	isBGPPrefix := false
-	prefixLengths, exists := bgpPrefixes[convertIPFromShortFieldToKeyInt(prefix, isIPv6)]
-	if exists {
-		isBGPPrefix = slices.Contains(prefixLengths, len(prefix))
-	}
-	return isBGPPrefix
-}
-
-func isSpecial(prefix []uint8, isIPv6 bool) bool {
-	isSpecialPrefix := false
-	prefixLengths, exists := specialPrefixes[convertIPFromShortFieldToKeyInt(prefix, isIPv6)]
-	if exists {
-		prefixLength := slices.Max(prefixLengths)
-		isSpecialPrefix = prefixLength <= len(prefix)
-	}
-	return isSpecialPrefix
-}
-
-func hasBGPsubnet(prefix []uint8) bool {
-	startKey := convertIPFromShortFieldToKeyInt(prefix, ipv6Scan)
-	endKey := calculateBiggestKeyInSubnet(prefix, ipv6Scan)
-	// Finds the smallest Index with a value larger than out network address (startKey)
-	smallestKeyAfterStartKeyIndex := sort.Search(len(bgpPrefixesSlice),
-		func(i int) bool {
-			return bgpPrefixesSlice[i] >= startKey
-		})
-	if len(bgpPrefixesSlice) == smallestKeyAfterStartKeyIndex {
-		return false
-	}
-	// Checks if the BGP prefixes network address is inside the checked prefix
-	return bgpPrefixesSlice[smallestKeyAfterStartKeyIndex] <= endKey && bgpPrefixesSlice[smallestKeyAfterStartKeyIndex] >= startKey
-}
-
 // Allocate and fill a new child node
-func makeNewNode(prefixUpToParent []uint8, thisValue uint8, kindOfNetParent uint8, isAnnounced bool) *node {
+func makeNewNode(family *addressFamily, prefixUpToParent ipPrefix, thisValue uint8, kindOfNetParent uint8, isAnnounced bool) *node {
 	// Default kind is UNANNOUNCED for all subnets
 	kindOfPrefix := uint8(UNANNOUNCED)
 
-	prefixHelpVariable := make([]uint8, len(prefixUpToParent))
-	copy(prefixHelpVariable, prefixUpToParent)
-	prefixIncludingValue := append(prefixHelpVariable, thisValue)
-	if kindOfNetParent == SPECIAL || isSpecial(prefixIncludingValue, ipv6Scan) {
+	prefixIncludingValue := prefixUpToParent.child(thisValue)
+	if kindOfNetParent == SPECIAL || family.isSpecial(prefixIncludingValue) {
 		kindOfPrefix = SPECIAL
-	} else if isBGPannounced(prefixIncludingValue, ipv6Scan) {
+	} else if family.isBGPannounced(prefixIncludingValue) {
 		kindOfPrefix = BGPANNOUNCED
 	}
-	hasBGPnet := hasBGPsubnet(prefixIncludingValue)
+	hasBGPnet := family.hasBGPsubnet(prefixIncludingValue)
 	newNode := node{ //childs are nil
 		whichKindofPrefix: kindOfPrefix,
 		value:             thisValue,
 		hasBGPsubnet:      hasBGPnet,
-		childs:            make([]trieElement, 2),
 		isAnnounced:       isAnnounced || kindOfPrefix == BGPANNOUNCED,
 	}
 	return &newNode
@@ -79,18 +40,18 @@ type trieElement interface {
 	finishThisTrieElement() trieElement // summarizes this Trie Element (a leaf is returned that stores how many (BGPANNOUNCED)scans have been performed in the subtree
 	finishChildElement(index uint8)
 	//howManyScansAndBGPScansInsideThisPrefix() (int, int)      // returns number of scans (first returned int) and BGPANNOUNCED scans (second returned int) were performed in the network of this Prefix (equally precise and more precise scans are included)
-	anyNotFinishedBGPSubnetsLeft(prefixUpToThis []uint8) bool // returns if there are any BGPANNOUNCED announced subnets in the current net, which have not been scansAnnounced enough (= are not finished)
-	hasBGPSubnet() bool                                       // returns hasBGPsubnet value
-	getChild(prefixUpToParent []uint8, index uint8) trieElement
-	markAsInResponse() bool // increment the number of times this prefix has been referred to in responses and return if scanning for this node is complete
+	anyNotFinishedBGPSubnetsLeft(family *addressFamily, prefixUpToThis ipPrefix) bool // returns if there are any BGPANNOUNCED announced subnets in the current net, which have not been scansAnnounced enough (= are not finished)
+	hasBGPSubnet() bool                                                               // returns hasBGPsubnet value
+	getChild(family *addressFamily, prefixUpToParent ipPrefix, index uint8) trieElement
+	markAsInResponse(family *addressFamily) bool // increment the number of times this prefix has been referred to in responses and return if scanning for this node is complete
 	getValue() uint8
 	wasScanned() bool
 	setScanned()
 	setChildScanned(isBGPAnnounced bool)
-	getScanningMode(currentPrefixUpToThis []uint8) int
+	getScanningMode(family *addressFamily, currentPrefixUpToThis ipPrefix) int
 	isBGPPrefix() bool
 	isInAnnouncedSpace() bool
-	isMarkedInResponse() bool
+	isMarkedInResponse(family *addressFamily) bool
 }
 
 type leaf struct {
@@ -129,7 +90,7 @@ func (currentLeaf *leaf) setChildScanned(isBGPAnnounced bool) {
 	}
 }
 
-func (currentLeaf *leaf) getScanningMode(_ []uint8) int {
+func (currentLeaf *leaf) getScanningMode(_ *addressFamily, _ ipPrefix) int {
 	return FINISHED_SCANNING
 }
 
@@ -145,7 +106,7 @@ func (currentLeaf *leaf) isInAnnouncedSpace() bool {
 	return currentLeaf.isAnnounced
 }
 
-func (currentLeaf *leaf) handleResponse(_ []uint8, _ uint8) trieElement { //we have already cut that subnet
+func (currentLeaf *leaf) handleResponse(_ ipPrefix, _ uint8) trieElement { //we have already cut that subnet
 	return currentLeaf
 }
 func (currentLeaf *leaf) finishThisTrieElement() trieElement {
@@ -157,29 +118,29 @@ func (currentLeaf *leaf) finishChildElement(_ uint8) {
 func (currentLeaf *leaf) howManyScansAndBGPScansInsideThisPrefix() (int, int) {
 	return currentLeaf.scansUnnanounced, currentLeaf.scansAnnounced
 }
-func (currentLeaf *leaf) getNewParameters(_ []uint8) ([]uint8, bool) {
+func (currentLeaf *leaf) getNewParameters(_ ipPrefix) (ipPrefix, bool) {
 	//if len(currentPrefix) == prefixLengthToScanWith {
 	//	if !currentLeaf.wasScanned {
 	//		currentLeaf.wasScanned = true
 	//		return append(currentPrefix, currentLeaf.value), true, false
 	//	}
 	//}
-	return nil, false
+	return ipPrefix{}, false
 }
 
-func (currentLeaf *leaf) anyNotFinishedBGPSubnetsLeft(_ []uint8) bool {
+func (currentLeaf *leaf) anyNotFinishedBGPSubnetsLeft(_ *addressFamily, _ ipPrefix) bool {
 	return false
 }
 
-func (currentLeaf *leaf) getChild(_ []uint8, _ uint8) trieElement {
+func (currentLeaf *leaf) getChild(_ *addressFamily, _ ipPrefix, _ uint8) trieElement {
 	return nil
 }
 
-func (currentLeaf *leaf) markAsInResponse() bool {
+func (currentLeaf *leaf) markAsInResponse(_ *addressFamily) bool {
 	return true
 }
 
-func (currentLeaf *leaf) isMarkedInResponse() bool {
+func (currentLeaf *leaf) isMarkedInResponse(_ *addressFamily) bool {
 	return true
 }
 
@@ -192,7 +153,7 @@ type node struct {
 	hasBGPsubnet           bool
 	isAnnounced            bool
 	value                  uint8 // 0 or 1
-	childs                 []trieElement
+	childs                 [2]trieElement
 }
 
 func (currentNode *node) getValue() uint8 {
@@ -248,28 +209,33 @@ func (currentNode *node) finishChildElement(index uint8) {
 	currentNode.childs[index] = currentNode.childs[index].finishThisTrieElement()
 }
 
-func (currentNode *node) getScanningMode(currentPrefixUpToThis []uint8) int {
-	depth := len(currentPrefixUpToThis)
+func (currentNode *node) getScanningMode(family *addressFamily, currentPrefixUpToThis ipPrefix) int {
+	depth := currentPrefixUpToThis.length
 
-	if currentNode.whichKindofPrefix == SPECIAL && maxSpecialPrefixScans <= currentNode.scansUnanounced {
-		debuglog("trie: finish scanning special prefix %v/%v", convertIPFromFieldToNetIP(currentPrefixUpToThis, ipv6Scan), depth)
+	if currentNode.whichKindofPrefix == SPECIAL && family.maxSpecialPrefixScans <= currentNode.scansUnanounced {
+		if family.logger.debugEnabled() {
+			family.logger.debuglog("trie: finish scanning special prefix %v", currentPrefixUpToThis.format(family.ipv6))
+		}
 		return FINISHED_SCANNING
 	}
 
-	if currentNode.isMarkedInResponse() {
-		if currentNode.anyNotFinishedBGPSubnetsLeft(currentPrefixUpToThis) && scanAllBGP {
+	if currentNode.isMarkedInResponse(family) {
+		if currentNode.anyNotFinishedBGPSubnetsLeft(family, currentPrefixUpToThis) && family.scanAllBGP {
 			return BGP_PREFIX_MODE
 		} else {
-			debuglog("trie: finish scanning as marked in response %v/%v", convertIPFromFieldToNetIP(currentPrefixUpToThis, ipv6Scan), depth)
+			if family.logger.debugEnabled() {
+				family.logger.debuglog("trie: finish scanning as marked in response %v", currentPrefixUpToThis.format(family.ipv6))
+			}
 			return FINISHED_SCANNING
 		}
 	}
 
-	var totalUnnanouncedLimitHit = currentNode.scansUnanounced+currentNode.scansAnounced >= totalNotroutedLimit
+	var totalUnnanouncedLimitHit = currentNode.scansUnanounced+currentNode.scansAnounced >= family.totalNotroutedLimit
 	var defaultMode = SAMPLE_MODE
 	if totalUnnanouncedLimitHit {
 		defaultMode = BGP_MODE
 	}
+	scanLimits := family.scanLimits
 	var noLimits = scanLimits[BGPANNOUNCED][depth] == 0 && scanLimits[UNANNOUNCED][depth] == 0 && scanLimits[TOTAL][depth] == 0
 	if noLimits {
 		return defaultMode
@@ -279,12 +245,14 @@ func (currentNode *node) getScanningMode(currentPrefixUpToThis []uint8) int {
 	var announcedLimitHit = scanLimits[BGPANNOUNCED][depth] != 0 && scanLimits[BGPANNOUNCED][depth] <= currentNode.scansAnounced
 	var unannouncedLimitHit = scanLimits[UNANNOUNCED][depth] != 0 && scanLimits[UNANNOUNCED][depth] <= currentNode.scansUnanounced
 	if totalLimitHit || announcedLimitHit || unannouncedLimitHit {
-		var bgpLeft = currentNode.anyNotFinishedBGPSubnetsLeft(currentPrefixUpToThis)
+		var bgpLeft = currentNode.anyNotFinishedBGPSubnetsLeft(family, currentPrefixUpToThis)
 		if totalLimitHit || announcedLimitHit {
-			if bgpLeft && scanAllBGP {
+			if bgpLeft && family.scanAllBGP {
 				return BGP_PREFIX_MODE
 			} else {
-				debuglog("trie: finish scanning - limit hit %v %v --- %v/%v", announcedLimitHit, totalLimitHit, convertIPFromFieldToNetIP(currentPrefixUpToThis, ipv6Scan), depth)
+				if family.logger.debugEnabled() {
+					family.logger.debuglog("trie: finish scanning - limit hit %v %v --- %v", announcedLimitHit, totalLimitHit, currentPrefixUpToThis.format(family.ipv6))
+				}
 				return FINISHED_SCANNING
 			}
 		} else {
@@ -295,7 +263,7 @@ func (currentNode *node) getScanningMode(currentPrefixUpToThis []uint8) int {
 		return defaultMode
 	}
 }
-func (currentNode *node) anyNotFinishedBGPSubnetsLeft(prefixUpToThis []uint8) bool { //prefixUpToThis includes the value of the currentNode already
+func (currentNode *node) anyNotFinishedBGPSubnetsLeft(family *addressFamily, prefixUpToThis ipPrefix) bool { //prefixUpToThis includes the value of the currentNode already
 
 	if currentNode.whichKindofPrefix == BGPANNOUNCED && !currentNode.wasScanned() {
 		//this node itself is a BGPANNOUNCED announced prefix and not finished yet.
@@ -303,14 +271,12 @@ func (currentNode *node) anyNotFinishedBGPSubnetsLeft(prefixUpToThis []uint8) bo
 	} else if !currentNode.hasBGPsubnet {
 		return false
 	}
-	if len(prefixUpToThis) == prefixLengthToScanWith {
+	if prefixUpToThis.length == family.prefixLengthToScanWith {
 		return false
 	}
 	for index := range currentNode.childs {
-		child := currentNode.getChild(prefixUpToThis, uint8(index))
-		prefixHelpVariable3 := make([]uint8, len(prefixUpToThis))
-		copy(prefixHelpVariable3, prefixUpToThis)
-		if child.anyNotFinishedBGPSubnetsLeft(append(prefixHelpVariable3, uint8(index))) {
+		child := currentNode.getChild(family, prefixUpToThis, uint8(index))
+		if child.anyNotFinishedBGPSubnetsLeft(family, prefixUpToThis.child(uint8(index))) {
 			return true
 		}
 	}
@@ -318,27 +284,28 @@ func (currentNode *node) anyNotFinishedBGPSubnetsLeft(prefixUpToThis []uint8) bo
 	return false
 }
 
-func (currentNode *node) getChild(currentPrefix []uint8, indexValue uint8) trieElement {
+func (currentNode *node) getChild(family *addressFamily, currentPrefix ipPrefix, indexValue uint8) trieElement {
 	if currentNode.childs[indexValue] == nil {
-		//debuglog("node: Making new root child %v for %v", indexValue, prefixUpToParent)
-		currentNode.childs[indexValue] = makeNewNode(currentPrefix, indexValue, currentNode.whichKindofPrefix, currentNode.isAnnounced)
+		//family.logger.debuglog("node: Making new root child %v for %v", indexValue, prefixUpToParent)
+		currentNode.childs[indexValue] = makeNewNode(family, currentPrefix, indexValue, currentNode.whichKindofPrefix, currentNode.isAnnounced)
 	}
 	return currentNode.childs[indexValue]
 }
 
-func (currentNode *node) markAsInResponse() bool {
+func (currentNode *node) markAsInResponse(family *addressFamily) bool {
 	currentNode.counterReturnedAsScope++
-	return currentNode.counterReturnedAsScope >= scanResultsToFinish
+	return currentNode.counterReturnedAsScope >= family.scanResultsToFinish
 }
 
-func (currentNode *node) isMarkedInResponse() bool {
-	return currentNode.counterReturnedAsScope >= scanResultsToFinish
+func (currentNode *node) isMarkedInResponse(family *addressFamily) bool {
+	return currentNode.counterReturnedAsScope >= family.scanResultsToFinish
 }
 
 type root struct {
+	family            *addressFamily // family of the client subnets in the trie
 	scopeZeroObserved int
 	rootIsScanned     bool
-	childs            []trieElement
+	childs            [2]trieElement
 }
 
 func (root *root) getValue() uint8 {
@@ -364,7 +331,7 @@ func (root *root) finishChildElement(index uint8) {
 }
 
 func (root *root) hasBGPSubnet() bool {
-	return len(bgpPrefixes) > 0
+	return root.family.bgpPrefixes.len() > 0
 }
 
 func (_ *root) isBGPPrefix() bool {
@@ -375,10 +342,10 @@ func (_ *root) isInAnnouncedSpace() bool {
 	return false
 }
 
-func (root *root) anyNotFinishedBGPSubnetsLeft(prefixUpToThis []uint8) bool {
+func (root *root) anyNotFinishedBGPSubnetsLeft(family *addressFamily, prefixUpToThis ipPrefix) bool {
 	for _, child := range root.childs {
 		if child != nil {
-			if child.anyNotFinishedBGPSubnetsLeft(prefixUpToThis) {
+			if child.anyNotFinishedBGPSubnetsLeft(family, prefixUpToThis) {
 				return true
 			}
 		}
@@ -386,72 +353,77 @@ func (root *root) anyNotFinishedBGPSubnetsLeft(prefixUpToThis []uint8) bool {
 	return false
 }
 
-func getNewParameters(nodeElement trieElement, prefixUpToParent []uint8) []uint8 {
-	prefix, _ := getNewParametersWithMode(nodeElement, prefixUpToParent, SAMPLE_MODE)
-	return prefix
+func getNewParameters(family *addressFamily, nodeElement trieElement, prefixUpToParent ipPrefix) (ipPrefix, bool) {
+	prefix, _, found := getNewParametersWithMode(family, nodeElement, prefixUpToParent, SAMPLE_MODE)
+	return prefix, found
 }
 
-func getNewParametersWithMode(nodeElement trieElement, prefixUpToParent []uint8, scanningMode int) ([]uint8, bool) {
+// getNewParametersWithMode returns the next prefix to scan below the element, whether it is announced and whether one was found
+func getNewParametersWithMode(family *addressFamily, nodeElement trieElement, prefixUpToParent ipPrefix, scanningMode int) (ipPrefix, bool, bool) {
+	prefixLengthToScanWith := family.prefixLengthToScanWith
 	if prefixLengthToScanWith <= 0 {
 		panic("prefixLengthToScanWith cannot be <= 0")
 	}
 
-	currentPrefixSlice := make([]uint8, len(prefixUpToParent))
+	currentPrefix := prefixUpToParent
 	switch nodeElement.(type) {
 	case *node:
-		copy(currentPrefixSlice, prefixUpToParent)
-		currentPrefixSlice = append(currentPrefixSlice, nodeElement.getValue())
+		currentPrefix = prefixUpToParent.child(nodeElement.getValue())
 	case *leaf:
-		return nil, false
+		return ipPrefix{}, false, false
 	}
-	lengthOfCurrentPrefix := len(currentPrefixSlice)
+	lengthOfCurrentPrefix := currentPrefix.length
 
-	var nodeScanningMode = nodeElement.getScanningMode(currentPrefixSlice)
+	var nodeScanningMode = nodeElement.getScanningMode(family, currentPrefix)
 	if nodeScanningMode == FINISHED_SCANNING {
-		return nil, false
+		return ipPrefix{}, false, false
 	}
 	if nodeScanningMode > scanningMode {
 		scanningMode = nodeScanningMode
 	}
 	if (scanningMode == BGP_MODE || scanningMode == BGP_PREFIX_MODE) && !nodeElement.hasBGPSubnet() && !nodeElement.isInAnnouncedSpace() {
-		return nil, false
+		return ipPrefix{}, false, false
 	}
 
 	// depth to scan with is reached
 	if lengthOfCurrentPrefix == prefixLengthToScanWith {
 		if nodeElement.wasScanned() {
-			return nil, false
+			return ipPrefix{}, false, false
 		} else if scanningMode == SAMPLE_MODE || (scanningMode == BGP_PREFIX_MODE && nodeElement.isBGPPrefix()) || (scanningMode == BGP_MODE && nodeElement.isInAnnouncedSpace()) {
 			nodeElement.setScanned()
-			return currentPrefixSlice, nodeElement.isBGPPrefix()
+			return currentPrefix, nodeElement.isBGPPrefix(), true
 		} else {
-			return nil, false
+			return ipPrefix{}, false, false
 		}
 	}
 
 	firstChildIndex := uint8(0)
-	if lengthOfCurrentPrefix >= randomizeDepth {
+	if lengthOfCurrentPrefix >= family.randomizeDepth {
 		firstChildIndex = uint8(rand.Int() % 2)
 	}
 	secondChildIndex := uint8(1)
 	if firstChildIndex == 1 {
 		secondChildIndex = 0
 	}
-	var searchOrder = make([]trieElement, 2)
+	var searchOrder [2]trieElement
 	var childAvailable = false
 	var onlySecondChildHasBGP = true
-	for sliceIndex, childIndex := range []uint8{firstChildIndex, secondChildIndex} {
-		searchOrder[sliceIndex] = nodeElement.getChild(currentPrefixSlice, childIndex)
+	for sliceIndex, childIndex := range [2]uint8{firstChildIndex, secondChildIndex} {
+		searchOrder[sliceIndex] = nodeElement.getChild(family, currentPrefix, childIndex)
 		switch searchOrder[sliceIndex].(type) {
 		case *leaf:
 			searchOrder[sliceIndex] = nil
 		default:
 			if scanningMode == BGP_PREFIX_MODE && !searchOrder[sliceIndex].isBGPPrefix() && !searchOrder[sliceIndex].hasBGPSubnet() {
-				debuglog("trie: finish child because of BGP prefix scanning mode %v/%v scanning mode %v", convertIPFromFieldToNetIP(append(currentPrefixSlice, searchOrder[sliceIndex].getValue()), ipv6Scan), lengthOfCurrentPrefix+1, scanningMode)
+				if family.logger.debugEnabled() {
+					family.logger.debuglog("trie: finish child because of BGP prefix scanning mode %v scanning mode %v", currentPrefix.child(searchOrder[sliceIndex].getValue()).format(family.ipv6), scanningMode)
+				}
 				nodeElement.finishChildElement(childIndex)
 				searchOrder[sliceIndex] = nil
 			} else if searchOrder[sliceIndex].wasScanned() {
-				debuglog("trie: finish child because it was scansAnnounced %v/%v scanning mode %v", convertIPFromFieldToNetIP(append(currentPrefixSlice, searchOrder[sliceIndex].getValue()), ipv6Scan), lengthOfCurrentPrefix+1, scanningMode)
+				if family.logger.debugEnabled() {
+					family.logger.debuglog("trie: finish child because it was scansAnnounced %v scanning mode %v", currentPrefix.child(searchOrder[sliceIndex].getValue()).format(family.ipv6), scanningMode)
+				}
 				nodeElement.finishChildElement(childIndex)
 				searchOrder[sliceIndex] = nil
 			} else {
@@ -465,19 +437,21 @@ func getNewParametersWithMode(nodeElement trieElement, prefixUpToParent []uint8,
 	}
 	if childAvailable {
 		if onlySecondChildHasBGP {
-			searchOrder = []trieElement{searchOrder[1], searchOrder[0]}
+			searchOrder[0], searchOrder[1] = searchOrder[1], searchOrder[0]
 		}
 
 		for index, child := range searchOrder {
 			if child == nil {
 				continue
 			}
-			childPrefix, prefixIsAnnounced := getNewParametersWithMode(child, currentPrefixSlice, scanningMode)
-			if childPrefix != nil {
+			childPrefix, prefixIsAnnounced, found := getNewParametersWithMode(family, child, currentPrefix, scanningMode)
+			if found {
 				nodeElement.setChildScanned(prefixIsAnnounced)
-				return childPrefix, prefixIsAnnounced || nodeElement.isBGPPrefix()
+				return childPrefix, prefixIsAnnounced || nodeElement.isBGPPrefix(), true
 			} else {
-				debuglog("trie: finish child because it told us no more scans to do %v/%v scanning mode %v", convertIPFromFieldToNetIP(append(currentPrefixSlice, child.getValue()), ipv6Scan), lengthOfCurrentPrefix+1, scanningMode)
+				if family.logger.debugEnabled() {
+					family.logger.debuglog("trie: finish child because it told us no more scans to do %v scanning mode %v", currentPrefix.child(child.getValue()).format(family.ipv6), scanningMode)
+				}
 				if index == 0 {
 					nodeElement.finishChildElement(firstChildIndex)
 				} else {
@@ -489,32 +463,32 @@ func getNewParametersWithMode(nodeElement trieElement, prefixUpToParent []uint8,
 
 	if nodeElement.isBGPPrefix() {
 		nodeElement.setScanned()
-		return currentPrefixSlice, true
+		return currentPrefix, true, true
 	}
-	return nil, false
+	return ipPrefix{}, false, false
 } // get the next prefix of Length prefixLength that has not been finished yet . if the second return value is false, it means that we have finished scanning for all prefixes with this prefix length
 
-func (root *root) getChild(prefixUpToParent []uint8, index uint8) trieElement {
+func (root *root) getChild(family *addressFamily, prefixUpToParent ipPrefix, index uint8) trieElement {
 	if root.childs[index] == nil {
-		debuglog("TRIE: Making new root child")
-		root.childs[index] = makeNewNode(prefixUpToParent, index, UNANNOUNCED, false)
+		family.logger.debuglog("TRIE: Making new root child")
+		root.childs[index] = makeNewNode(family, prefixUpToParent, index, UNANNOUNCED, false)
 	}
 	return root.childs[index]
 }
 
-func (_ *root) markAsInResponse() bool {
+func (_ *root) markAsInResponse(_ *addressFamily) bool {
 	return false
 }
 
-func (_ *root) isMarkedInResponse() bool {
+func (_ *root) isMarkedInResponse(_ *addressFamily) bool {
 	return false
 }
 
-func (root *root) getScanningMode(currentPrefixUpToThis []uint8) int {
+func (root *root) getScanningMode(family *addressFamily, currentPrefixUpToThis ipPrefix) int {
 	var scanningMode = FINISHED_SCANNING
 	for _, child := range root.childs {
 		if child != nil {
-			var childMode = child.getScanningMode(currentPrefixUpToThis)
+			var childMode = child.getScanningMode(family, currentPrefixUpToThis)
 			if childMode < scanningMode {
 				scanningMode = childMode
 			}
@@ -527,27 +501,27 @@ func (root *root) getScanningMode(currentPrefixUpToThis []uint8) int {
 
 // increments the counter of indications from the ANS that there will be the same answer for all IPs in a certain subnet. If the counter exceeds a threshold the subtree will be summarized
 // depth of root is -1!
-func handleResponse(currentNode trieElement, shortenedLastClientIP []uint8, depth uint8) bool {
+func handleResponse(family *addressFamily, currentNode trieElement, shortenedLastClientIP ipPrefix, depth int) bool {
 	if currentNode == nil {
 		// found leaf node -> we do not care anymore about results there
 		return false
 	}
-	if uint8(len(shortenedLastClientIP)) == depth {
-		return currentNode.markAsInResponse()
+	if shortenedLastClientIP.length == depth {
+		return currentNode.markAsInResponse(family)
 	} else { //we have not reached the responsible node that represents the received lastClientIP/scopePrefixLength
-		if handleResponse(currentNode.getChild(shortenedLastClientIP[:depth], shortenedLastClientIP[depth]), shortenedLastClientIP, depth+1) {
-			return currentNode.getScanningMode(shortenedLastClientIP[:depth]) == FINISHED_SCANNING
+		if handleResponse(family, currentNode.getChild(family, shortenedLastClientIP.truncate(depth), shortenedLastClientIP.bit(depth)), shortenedLastClientIP, depth+1) {
+			return currentNode.getScanningMode(family, shortenedLastClientIP.truncate(depth)) == FINISHED_SCANNING
 		} else {
 			return false
 		}
 	}
 }
 
-func (root *root) rootHandleResponse(shortenedLastClientIP []uint8) bool {
-	if len(shortenedLastClientIP) > 0 {
-		return handleResponse(root, shortenedLastClientIP, 0)
+func (root *root) rootHandleResponse(shortenedLastClientIP ipPrefix) bool {
+	if shortenedLastClientIP.length > 0 {
+		return handleResponse(root.family, root, shortenedLastClientIP, 0)
 	} else {
 		root.scopeZeroObserved += 1
-		return maxNumScopeZeros > 0 && root.scopeZeroObserved > maxNumScopeZeros
+		return root.family.maxNumScopeZeros > 0 && root.scopeZeroObserved > root.family.maxNumScopeZeros
 	}
 }
@@ -0,0 +1,138 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package scan
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+	"testing"
+)
+
+// trieTestConfig returns the limits and prefixes the golden query sequences were recorded with
+func trieTestConfig() Config {
+	config := DefaultConfig()
+	config.DualStack = true
+	config.Sink = discardSink{}
+	config.RandomizeDepth = 200 // no random order, the sequence is deterministic
+	config.LimitsIPv4 = Limits{
+		Announced:             map[int]int{24: 1, 16: 4, 8: 16},
+		Unannounced:           map[int]int{8: 2},
+		Total:                 map[int]int{24: 1, 16: 4, 8: 16},
+		MaxSpecialPrefixScans: 2,
+		ScanResultsToFinish:   1,
+		TotalNotroutedLimit:   65536,
+	}
+	config.LimitsIPv6 = Limits{
+		Announced:             map[int]int{48: 1, 40: 2, 32: 4},
+		Unannounced:           map[int]int{16: 1},
+		Total:                 map[int]int{48: 1, 32: 4, 16: 2},
+		MaxSpecialPrefixScans: 2,
+		ScanResultsToFinish:   1,
+		TotalNotroutedLimit:   10,
+	}
+	for _, prefix := range []string{"1.0.0.0/8", "8.8.0.0/16", "2001:db8::/32"} {
+		config.BGPPrefixes = append(config.BGPPrefixes, netip.MustParsePrefix(prefix))
+	}
+	for _, prefix := range []string{"1.2.0.0/16", "8.8.8.0/24", "2001:db8:1::/48"} {
+		config.SpecialPrefixes = append(config.SpecialPrefixes, netip.MustParsePrefix(prefix))
+	}
+	return config
+}
+
+// scriptedScope is the scope the nameserver of the golden sequences returns for a client subnet
+func scriptedScope(address netip.Addr) byte {
+	switch {
+	case netip.MustParsePrefix("1.2.0.0/16").Contains(address):
+		return 24
+	case netip.MustParsePrefix("1.0.0.0/8").Contains(address):
+		return 16
+	case netip.MustParsePrefix("8.8.0.0/16").Contains(address):
+		return 20
+	case netip.MustParsePrefix("2001:db8::/32").Contains(address):
+		return 40
+	case address.Is4():
+		return 8 + address.As4()[0]%3*4
+	}
+	return 16 + address.As16()[0]%2*8
+}
+
+// walkTrie scans a domain with the trie generator until it finishes, scope returns the scope of every query.
+// It returns one line "client subnet scope" per query.
+func walkTrie(scanner *Scanner, family *addressFamily, scope func(netip.Addr, byte) byte) []string {
+	domainState := &domainState{scanner: scanner, domain: "golden.example", family: family}
+	generator := newTrieGenerator(domainState)
+	var queries []string
+	for {
+		result := generator.Next()
+		if result.kind != RESULT_QUERY {
+			return queries
+		}
+		query := result.queries[0]
+		address, _ := netip.AddrFromSlice(query.ipAddressClient)
+		address = address.Unmap()
+		responseScope := scope(address, query.sourcePrefixLength)
+		queries = append(queries, fmt.Sprintf("%v/%v %v", address, query.sourcePrefixLength, responseScope))
+		generator.Consume(&queryResponse{request: query, scopePrefixLength: responseScope})
+	}
+}
+
+// The trie has to query exactly the client subnets the trie on []uint8 bit fields queried before it was moved to packed prefixes
+func TestTrieGoldenSequence(t *testing.T) {
+	scanner, err := New(trieTestConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range []*addressFamily{scanner.ipv4, scanner.ipv6} {
+		golden, err := os.ReadFile("testdata/trie_" + family.name + ".golden")
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := strings.Split(strings.TrimSpace(string(golden)), "\n")
+		queries := walkTrie(scanner, family, func(address netip.Addr, _ byte) byte { return scriptedScope(address) })
+		for i := 0; i < len(expected) || i < len(queries); i++ {
+			if i >= len(queries) || i >= len(expected) || queries[i] != expected[i] {
+				t.Fatalf("%v: query %v differs, got %v queries, expected %v\n got: %v\nexpected: %v", family.name, i, len(queries), len(expected), queries[min(i, len(queries)-1)], expected[min(i, len(expected)-1)])
+			}
+		}
+	}
+}
+
+func BenchmarkGetNewParameters(b *testing.B) {
+	scanner, err := New(trieTestConfig())
+	if err != nil {
+		b.Fatal(err)
+	}
+	queries := 0
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		queries += len(walkTrie(scanner, scanner.ipv4, func(address netip.Addr, _ byte) byte { return scriptedScope(address) }))
+	}
+	b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(queries), "ns/query")
+}
+
+// BenchmarkTrieIPv6 scans /48 client subnets of a nameserver returning the source prefix length as scope,
+// which makes the trie query every /48 the limits allow
+func BenchmarkTrieIPv6(b *testing.B) {
+	config := trieTestConfig()
+	config.LimitsIPv6 = Limits{
+		Announced:           map[int]int{48: 1, 40: 16, 32: 256},
+		Unannounced:         map[int]int{16: 1},
+		Total:               map[int]int{48: 1},
+		ScanResultsToFinish: 1,
+		TotalNotroutedLimit: 64,
+	}
+	scanner, err := New(config)
+	if err != nil {
+		b.Fatal(err)
+	}
+	queries := 0
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		queries += len(walkTrie(scanner, scanner.ipv6, func(_ netip.Addr, source byte) byte { return source }))
+	}
+	b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(queries), "ns/query")
+}
@@ -0,0 +1,353 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package scan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// the resolution of the timeout wheel
+const udpWheelTick = 10 * time.Millisecond
+
+// random IDs tried for a query before it fails, nearly all IDs to the nameserver are in use if none of them is free
+const udpIDAttempts = 64
+
+var errUDPTimeout = errors.New("udp query timed out")
+var errUDPNoFreeID = errors.New("no free dns id, too many queries to the nameserver are outstanding")
+
+// udpQueryEngine sends the UDP queries of all scanners over a small pool of long-lived sockets.
+// Responses are matched to the outstanding queries by DNS ID, nameserver address and question.
+// Queries without a response are retransmitted by the timeout wheel after the retry backoff until they run out of attempts.
+type udpQueryEngine struct {
+	scanner    *Scanner
+	sockets    []*udpSocket
+	next       atomic.Uint32 // socket for the next query, round robin
+	timeout    time.Duration
+	wheel      *timeoutWheel
+	retransmit chan *udpQuery // queries which timed out and have attempts left
+	done       chan struct{}  // closed to stop the timeout wheel and the retransmitter
+}
+
+type udpSocket struct {
+	conn    *net.UDPConn
+	mutex   sync.Mutex
+	pending map[udpQueryKey]*udpQuery
+}
+
+type udpQueryKey struct {
+	id     uint16
+	server netip.AddrPort
+}
+
+// udpQuery is an outstanding query of a scanner
+type udpQuery struct {
+	key      udpQueryKey
+	socket   *udpSocket
+	question dns.Question
+	packet   []byte
+	attempts int       // attempts left, including the one in flight
+	sent     time.Time // time of the last transmission
+	sends    int       // number of transmissions, entries of older transmissions in the wheel are ignored
+	done     chan udpResult
+}
+
+type udpResult struct {
+	response *dns.Msg
+	rtt      time.Duration
+	sends    int
+	err      error
+}
+
+// newUDPQueryEngine opens the Config.UDPSockets sockets and starts the receivers, the timeout wheel and the retransmitter
+func newUDPQueryEngine(scanner *Scanner) (*udpQueryEngine, error) {
+	config := &scanner.config
+	engine := &udpQueryEngine{
+		scanner:    scanner,
+		timeout:    config.TimeoutRead,
+		retransmit: make(chan *udpQuery, 1024),
+		done:       make(chan struct{}),
+	}
+	engine.wheel = newTimeoutWheel(config.TimeoutRead+config.RetryBackoffCap, engine.expired, engine.done)
+	for i := 0; i < config.UDPSockets; i++ {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: config.LocalAddress})
+		if err != nil {
+			for _, socket := range engine.sockets {
+				socket.conn.Close()
+			}
+			return nil, fmt.Errorf("can't open udp socket: %w", err)
+		}
+		socket := &udpSocket{
+			conn:    conn,
+			pending: make(map[udpQueryKey]*udpQuery),
+		}
+		engine.sockets = append(engine.sockets, socket)
+		go engine.receive(socket)
+	}
+	go engine.wheel.run()
+	go engine.retransmitter()
+	scanner.config.Logger.debuglog("UDPENGINE: Opened %v sockets", config.UDPSockets)
+	return engine, nil
+}
+
+// exchange sends the query and waits for the response, a query is sent at most attempts times.
+// It returns the number of transmissions as well. Once ctx is done the query is abandoned.
+func (engine *udpQueryEngine) exchange(ctx context.Context, msg *dns.Msg, server string, attempts int) (*dns.Msg, time.Duration, int, error) {
+	serverAddress, err := netip.ParseAddrPort(server)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	serverAddress = netip.AddrPortFrom(serverAddress.Addr().Unmap(), serverAddress.Port())
+	socket := engine.sockets[engine.next.Add(1)%uint32(len(engine.sockets))]
+	query := &udpQuery{
+		socket:   socket,
+		question: msg.Question[0],
+		attempts: attempts,
+		done:     make(chan udpResult, 1),
+	}
+
+	socket.mutex.Lock()
+	// the ID has to be unique among the outstanding queries to this nameserver
+	free := false
+	for i := 0; i < udpIDAttempts && !free; i++ {
+		query.key = udpQueryKey{id: dns.Id(), server: serverAddress}
+		_, used := socket.pending[query.key]
+		free = !used
+	}
+	if !free {
+		socket.mutex.Unlock()
+		return nil, 0, 0, errUDPNoFreeID
+	}
+	msg.Id = query.key.id
+	query.packet, err = msg.Pack()
+	if err != nil {
+		socket.mutex.Unlock()
+		return nil, 0, 0, err
+	}
+	socket.pending[query.key] = query
+	socket.mutex.Unlock()
+
+	engine.send(query)
+	select {
+	case result := <-query.done:
+		return result.response, result.rtt, result.sends, result.err
+	case <-ctx.Done():
+		socket.mutex.Lock()
+		if socket.pending[query.key] == query {
+			delete(socket.pending, query.key)
+			sends := query.sends
+			socket.mutex.Unlock()
+			return nil, 0, sends, ctx.Err()
+		}
+		socket.mutex.Unlock()
+		// the result was handed over in the meantime
+		result := <-query.done
+		return result.response, result.rtt, result.sends, result.err
+	}
+}
+
+// close closes the sockets and stops the timeout wheel and the retransmitter, it is called once the scan finished
+func (engine *udpQueryEngine) close() {
+	close(engine.done)
+	for _, socket := range engine.sockets {
+		socket.conn.Close()
+	}
+}
+
+// send transmits the query and schedules its timeout
+func (engine *udpQueryEngine) send(query *udpQuery) {
+	query.socket.mutex.Lock()
+	if query.socket.pending[query.key] != query {
+		// the response arrived in the meantime
+		query.socket.mutex.Unlock()
+		return
+	}
+	query.sent = time.Now()
+	query.sends++
+	sends := query.sends
+	query.socket.mutex.Unlock()
+
+	_, err := query.socket.conn.WriteToUDPAddrPort(query.packet, query.key.server)
+	if err != nil {
+		engine.finish(query, udpResult{sends: sends, err: err})
+		return
+	}
+	engine.wheel.add(wheelEntry{query: query, sends: sends}, engine.timeout)
+}
+
+// expired is called by the timeout wheel when a transmission of a query got no response in time
+// or when the backoff before its retransmission is over
+func (engine *udpQueryEngine) expired(entry wheelEntry) {
+	query := entry.query
+	query.socket.mutex.Lock()
+	if query.socket.pending[query.key] != query || query.sends != entry.sends {
+		query.socket.mutex.Unlock()
+		return
+	}
+	if entry.resend {
+		query.socket.mutex.Unlock()
+		engine.retransmitLater(query)
+		return
+	}
+	query.attempts--
+	if query.attempts > 0 {
+		query.socket.mutex.Unlock()
+		engine.scanner.stats.retries.Add(1)
+		backoff := engine.scanner.retryBackoff(entry.sends)
+		if backoff < udpWheelTick {
+			engine.retransmitLater(query)
+		} else {
+			engine.wheel.add(wheelEntry{query: query, sends: entry.sends, resend: true}, backoff)
+		}
+		return
+	}
+	delete(query.socket.pending, query.key)
+	query.socket.mutex.Unlock()
+	query.done <- udpResult{sends: entry.sends, err: errUDPTimeout}
+}
+
+// retransmitLater hands the query to the retransmitter unless the engine is closed
+func (engine *udpQueryEngine) retransmitLater(query *udpQuery) {
+	select {
+	case engine.retransmit <- query:
+	case <-engine.done:
+	}
+}
+
+func (engine *udpQueryEngine) retransmitter() {
+	for {
+		select {
+		case query := <-engine.retransmit:
+			engine.scanner.config.Logger.debuglog("UDPENGINE: Retransmitting query %v to %v", query.key.id, query.key.server)
+			engine.send(query)
+		case <-engine.done:
+			return
+		}
+	}
+}
+
+// finish removes the query from the outstanding queries and hands the result to the waiting scanner
+func (engine *udpQueryEngine) finish(query *udpQuery, result udpResult) {
+	query.socket.mutex.Lock()
+	if query.socket.pending[query.key] != query {
+		query.socket.mutex.Unlock()
+		return
+	}
+	delete(query.socket.pending, query.key)
+	query.socket.mutex.Unlock()
+	query.done <- result
+}
+
+// receive reads the responses of a socket and matches them to the outstanding queries
+func (engine *udpQueryEngine) receive(socket *udpSocket) {
+	buffer := make([]byte, dns.MaxMsgSize)
+	for {
+		n, from, err := socket.conn.ReadFromUDPAddrPort(buffer)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		} else if err != nil {
+			// e.g. ICMP errors of earlier queries, the socket stays usable for the other queries
+			engine.scanner.config.Logger.errorlog("UDPENGINE: Could not read from socket: %s", err)
+			select {
+			case <-engine.done:
+				return
+			default:
+				continue
+			}
+		}
+		received := time.Now()
+		response := new(dns.Msg)
+		err = response.Unpack(buffer[:n])
+		if err != nil {
+			engine.scanner.config.Logger.debuglog("UDPENGINE: Dropping response from %v which can't be parsed: %s", from, err)
+			continue
+		}
+		key := udpQueryKey{id: response.Id, server: netip.AddrPortFrom(from.Addr().Unmap(), from.Port())}
+		socket.mutex.Lock()
+		query, ok := socket.pending[key]
+		if !ok || !questionMatches(response, query.question) {
+			socket.mutex.Unlock()
+			engine.scanner.config.Logger.debuglog("UDPENGINE: Dropping unexpected response %v from %v", response.Id, from)
+			continue
+		}
+		delete(socket.pending, key)
+		rtt := received.Sub(query.sent)
+		sends := query.sends
+		socket.mutex.Unlock()
+		query.done <- udpResult{response: response, rtt: rtt, sends: sends}
+	}
+}
+
+// questionMatches checks that the response answers the question of the query
+func questionMatches(response *dns.Msg, question dns.Question) bool {
+	if len(response.Question) != 1 {
+		return false
+	}
+	return response.Question[0].Qtype == question.Qtype &&
+		response.Question[0].Qclass == question.Qclass &&
+		strings.EqualFold(response.Question[0].Name, question.Name)
+}
+
+// timeoutWheel calls expired for every entry once its delay is over, the delay is at most the one the wheel was created with.
+// The entries are put into the slot of the tick they expire in, so each tick only looks at the expired ones.
+type timeoutWheel struct {
+	mutex   sync.Mutex
+	slots   [][]wheelEntry
+	current int
+	expired func(entry wheelEntry)
+	done    <-chan struct{} // closed to stop the wheel
+}
+
+type wheelEntry struct {
+	query  *udpQuery
+	sends  int
+	resend bool // the entry ends the backoff before a retransmission instead of waiting for a response
+}
+
+func newTimeoutWheel(maxDelay time.Duration, expired func(entry wheelEntry), done <-chan struct{}) *timeoutWheel {
+	ticks := int(maxDelay/udpWheelTick) + 1
+	return &timeoutWheel{
+		slots:   make([][]wheelEntry, ticks+1),
+		expired: expired,
+		done:    done,
+	}
+}
+
+func (wheel *timeoutWheel) add(entry wheelEntry, delay time.Duration) {
+	ticks := min(int(delay/udpWheelTick)+1, len(wheel.slots)-1)
+	wheel.mutex.Lock()
+	slot := (wheel.current + ticks) % len(wheel.slots)
+	wheel.slots[slot] = append(wheel.slots[slot], entry)
+	wheel.mutex.Unlock()
+}
+
+func (wheel *timeoutWheel) run() {
+	ticker := time.NewTicker(udpWheelTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-wheel.done:
+			return
+		}
+		wheel.mutex.Lock()
+		wheel.current = (wheel.current + 1) % len(wheel.slots)
+		entries := wheel.slots[wheel.current]
+		wheel.slots[wheel.current] = nil
+		wheel.mutex.Unlock()
+		for _, entry := range entries {
+			wheel.expired(entry)
+		}
+	}
+}
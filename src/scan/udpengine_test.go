@@ -0,0 +1,113 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package scan
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// newUDPServer starts a nameserver on a random local port which answers every query with an empty response
+func newUDPServer(tb testing.TB) string {
+	started := make(chan struct{})
+	server := &dns.Server{
+		Addr:              "127.0.0.1:0",
+		Net:               "udp",
+		NotifyStartedFunc: func() { close(started) },
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, query *dns.Msg) {
+			w.WriteMsg(new(dns.Msg).SetReply(query))
+		}),
+	}
+	go server.ListenAndServe()
+	<-started
+	tb.Cleanup(func() { server.Shutdown() })
+	return server.PacketConn.LocalAddr().String()
+}
+
+func newUDPScanner(tb testing.TB) *Scanner {
+	config := DefaultConfig()
+	config.Sink = discardSink{}
+	config.UDPSockets = 4
+	scanner, err := New(config)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	err = scanner.start()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(scanner.closeTransports)
+	return scanner
+}
+
+// A query fails instead of looking for an ID forever once all IDs to its nameserver are in use
+func TestUDPEngineNoFreeID(t *testing.T) {
+	scanner := newUDPScanner(t)
+	engine := scanner.udpEngine
+	server := newUDPServer(t)
+	serverAddress := netip.MustParseAddrPort(server)
+	for _, socket := range engine.sockets {
+		for id := 0; id <= math.MaxUint16; id++ {
+			socket.pending[udpQueryKey{id: uint16(id), server: serverAddress}] = &udpQuery{}
+		}
+	}
+
+	msg := new(dns.Msg).SetQuestion("a.example.", dns.TypeA)
+	_, _, _, err := engine.exchange(context.Background(), msg, server, 1)
+	if err != errUDPNoFreeID {
+		t.Fatalf("expected %v, got %v", errUDPNoFreeID, err)
+	}
+
+	// other nameservers are not affected
+	response, _, _, err := engine.exchange(context.Background(), msg, newUDPServer(t), 1)
+	if err != nil || response.Id != msg.Id {
+		t.Errorf("expected a response from the other nameserver, got %v", err)
+	}
+}
+
+// A read error does not stop the receiver of a socket, the queries sent over it afterwards are still answered
+func TestUDPEngineReadError(t *testing.T) {
+	scanner := newUDPScanner(t)
+	engine := scanner.udpEngine
+	server := newUDPServer(t)
+	for _, socket := range engine.sockets {
+		socket.conn.SetReadDeadline(time.Now())
+	}
+	time.Sleep(20 * time.Millisecond)
+	for _, socket := range engine.sockets {
+		socket.conn.SetReadDeadline(time.Time{})
+	}
+	for i := range engine.sockets {
+		msg := new(dns.Msg).SetQuestion(fmt.Sprintf("q%v.example.", i), dns.TypeA)
+		_, _, _, err := engine.exchange(context.Background(), msg, server, 1)
+		if err != nil {
+			t.Errorf("query %v: %v", i, err)
+		}
+	}
+}
+
+func BenchmarkUDPEngine(b *testing.B) {
+	scanner := newUDPScanner(b)
+	server := newUDPServer(b)
+	b.SetParallelism(16)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			i++
+			msg := new(dns.Msg).SetQuestion(fmt.Sprintf("q%v.example.", i), dns.TypeA)
+			_, _, _, err := scanner.udpEngine.exchange(context.Background(), msg, server, 3)
+			if err != nil {
+				b.Error(err)
+			}
+		}
+	})
+}